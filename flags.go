@@ -0,0 +1,680 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aaron0x/rearview/rearview"
+)
+
+// options are values of flags, and what validate parses of them
+type options struct {
+	verbose         bool
+	quiet           bool
+	logLevel        string
+	traceFile       string
+	realTrace       bool
+	capital         amountFlag
+	files           fileFlag
+	ticker          string
+	source          string
+	timeout         time.Duration
+	runs            int
+	yearPerRun      int
+	horizonYears    int
+	inflationRate   float64
+	allowDeflation  bool
+	costPerYear     amountFlag
+	priceField      string
+	buyPrice        string
+	sellPrice       string
+	workers         int
+	format          string
+	taxRate         float64
+	costBasis       string
+	tradeFee        rearview.Fee
+	wholeShares     bool
+	cpiPath         string
+	strategyName    string
+	percent         float64
+	targetMargin    float64
+	floor           int
+	ceiling         int
+	guardrail       float64
+	guardrailAdjust float64
+	monteCarlo      int
+	seed            int64
+	startDate       string
+	startDays       string
+	endDate         string
+	bondsPath       string
+	alloc           string
+	withdrawFrom    string
+	cashAlloc       float64
+	reserveYears    float64
+	cashReturn      float64
+	spendingPath    string
+	withdrawMode    string
+	pessimistic     bool
+	maxGap          int
+	dividendYield   float64
+	phases          phaseFlag
+	income          int
+	accumulateYears int
+	contribution    amountFlag
+	incomeStartYear int
+	showProgress    bool
+	outPath         string
+	showIRR         bool
+	ledgerPath      string
+	trajectoryPath  string
+	dateColumn      string
+	priceColumn     string
+	dateIndex       int
+	priceIndex      int
+	delim           string
+	decimal         string
+	noHeader        bool
+	missing         string
+	minRate         float64
+	sweepCost       string
+	sweepCapital    string
+	showStats       bool
+	validateOnly    bool
+	capitalRange    string
+	trials          int
+	solveCost       float64
+	compareName     string
+	partial         bool
+	naAsFail        bool
+	swr             bool
+	anniversaryDay  string
+	perpetual       bool
+	crash           float64
+	crashYears      int
+	tableFlag       string
+	bucketYears     int
+	downsample      string
+	sortDates       bool
+	configPath      string
+
+	// set are flags set on the command line or by -config
+	set map[string]bool
+	// parsed by validate
+	sweepCosts       []int64
+	sweepCapitals    []int64
+	capitalLow       int64
+	capitalHigh      int64
+	starts           []time.Time
+	withdrawStrategy rearview.Strategy
+	compareStrategy  rearview.Strategy
+}
+
+// parseFlags parses flags of the command line, and of -config for those not set on the command line
+func parseFlags() (*options, error) {
+	o := &options{
+		capital:     333333,
+		files:       fileFlag{paths: []string{"./GSPC.csv"}},
+		costPerYear: 16666,
+	}
+	flag.BoolVar(&o.verbose, "v", false, "show verbose progress, same as -log-level trace")
+	flag.BoolVar(&o.quiet, "quiet", false, "print only the successful rate, or the cost of -solve-cost and the rate of -swr, it can't be used with -v, traces or other reports")
+	flag.StringVar(&o.logLevel, "log-level", "info", "info prints results only, trace prints progress of the simulation too, debug prints details of trades too")
+	flag.StringVar(&o.traceFile, "trace-file", "", "write traces to this file instead of stdout, it implies -v")
+	flag.BoolVar(&o.realTrace, "real", false, "trace dollar amounts in dollars of the start day of each period instead of nominal dollars")
+	flag.Var(&o.capital, "c", "initial capital, a suffix k, M or B multiplies it like 1.5M")
+	flag.Var(&o.files, "f", "input csv path, it can be gzip compressed, - to read from stdin, comma separated paths or globs like GSPC-*.csv are merged by date, "+
+		"repeat -f path:weight to blend indices like -f GSPC.csv:70 -f intl.csv:30, rebalanced daily over the days all of them have")
+	flag.StringVar(&o.ticker, "ticker", "", "download prices of this ticker like ^spx from -source instead of reading -f")
+	flag.StringVar(&o.source, "source", "stooq", "where -ticker is downloaded from, only stooq for now")
+	flag.DurationVar(&o.timeout, "timeout", 30*time.Second, "timeout of downloading -ticker")
+	flag.IntVar(&o.runs, "r", 5, "how many runs to test")
+	flag.IntVar(&o.yearPerRun, "y", 10, "how many years in one run")
+	flag.IntVar(&o.horizonYears, "horizon", 0, "total years of a period, it's runs of -y years, -y is 1 with -horizon unless it's set, so -horizon 30 is 30 runs of a year")
+	flag.Float64Var(&o.inflationRate, "i", 1.016, "inflation rate per year as 1 + rate, e.g. 1.016 is 1.6%, not 0.016")
+	flag.BoolVar(&o.allowDeflation, "allow-deflation", false, "allow -i below 1, prices fall every year then")
+	flag.Var(&o.costPerYear, "l", "cost per year, a suffix k, M or B multiplies it like 16.6k")
+	// high assumes you always trade at the best price of the day, close is more realistic
+	flag.StringVar(&o.priceField, "price", "high", "price used to buy and sell, open, high, low, close or adjclose (close is more realistic)")
+	flag.StringVar(&o.buyPrice, "buy-price", "", "price of the initial purchase if it's not -price")
+	flag.StringVar(&o.sellPrice, "sell-price", "", "price used to sell and value the portfolio, it overrides -price")
+	flag.IntVar(&o.workers, "j", runtime.NumCPU(), "how many start days to check in parallel, traces of different start days interleave unless it's 1")
+	flag.StringVar(&o.format, "format", "text", "output format, text, json, markdown, or jsonl which streams a line of json per start day or trial to stdout as it's checked and prints everything else to stderr")
+	flag.Float64Var(&o.taxRate, "tax", 0, "capital gains tax rate, e.g. 0.15")
+	flag.StringVar(&o.costBasis, "cost-basis", "average", "how gains of a sale are taxed, average of the cost of every share held, or fifo of the cost of the oldest shares")
+	flag.Var(&o.tradeFee, "fee", "fee per sale, a flat amount like 10, a percentage like 0.5%, or both like 10+0.5%")
+	flag.BoolVar(&o.wholeShares, "whole-shares", false, "only buy and sell whole shares")
+	flag.StringVar(&o.cpiPath, "cpi", "", "csv of cpi to compute inflation from instead of -i, columns are date and cpi")
+	flag.StringVar(&o.strategyName, "strategy", "fixed", "withdrawal strategy, fixed withdraws inflation adjusted cost per year once capital reaches the inflation adjusted principal plus it, constant withdraws it at the start of each run until money runs out, percent withdraws -percent of capital per year, guardrails starts from -percent and adjusts by guyton-klinger rules")
+	flag.Float64Var(&o.percent, "percent", 0.04, "rate of capital withdrawn per year by percent and guardrails strategy, cost per year is the least acceptable withdrawal")
+	flag.Float64Var(&o.targetMargin, "target-margin", 1, "with fixed strategy, sell only once capital reaches target capital times this, like 1.1 for 10% above it")
+	flag.IntVar(&o.floor, "floor", 0, "with percent strategy, the least withdrawal per year in dollars of the start day, inflation adjusted, the withdrawal is raised to it and the period fails only if capital runs out")
+	flag.IntVar(&o.ceiling, "ceiling", 0, "with percent strategy, the most withdrawal per year in dollars of the start day, inflation adjusted")
+	flag.Float64Var(&o.guardrail, "guardrail", 0.2, "with guardrails strategy, how far the withdrawal rate may drift from -percent before it's adjusted, 0.2 is 20%")
+	flag.Float64Var(&o.guardrailAdjust, "guardrail-adjust", 0.1, "with guardrails strategy, how much the withdrawal is cut or raised at a guardrail")
+	flag.IntVar(&o.monteCarlo, "montecarlo", 0, "check this many synthetic price paths resampled from daily returns instead of every historical start day")
+	flag.Int64Var(&o.seed, "seed", 1, "random seed of -montecarlo")
+	flag.StringVar(&o.startDate, "start", "", "only use data from this date, yyyy-mm-dd")
+	flag.StringVar(&o.startDays, "starts", "", "comma separated yyyy-mm-dd, check only periods starting on the closest days of these dates like 1999-12-31,2007-10-09 instead of every day, and print each of them")
+	flag.StringVar(&o.endDate, "end", "", "only use data until this date, yyyy-mm-dd")
+	flag.StringVar(&o.bondsPath, "bonds", "", "csv of bond prices to hold along with stocks, in the same format as -f")
+	flag.StringVar(&o.alloc, "alloc", "60/40", "stocks/bonds allocation with -bonds, rebalanced at start of each run")
+	flag.StringVar(&o.withdrawFrom, "withdraw-from", "proportional", "with -bonds, withdraw from stocks and bonds in proportion to their value, or bonds first")
+	flag.Float64Var(&o.cashAlloc, "cash-alloc", 0, "fraction of capital held as cash, cost of living is drawn from cash first")
+	flag.Float64Var(&o.reserveYears, "reserve", 0, "years of cost of living held as a cash reserve, drawn before selling shares and topped up from sales after the withdrawal of a run while capital is above the inflation adjusted initial capital")
+	flag.Float64Var(&o.cashReturn, "cash-return", 0.02, "annual return of cash with -cash-alloc")
+	flag.StringVar(&o.spendingPath, "spending", "", "csv of year offset and multiplier of cost per year, a missing year uses the multiplier of the year before it")
+	flag.StringVar(&o.withdrawMode, "withdraw", "lump", "withdraw cost of living of a run at once, or monthly at each month's price")
+	flag.BoolVar(&o.pessimistic, "pessimistic", false, "stress timing by selling at the lowest low price of each run, or the low price of each month with -withdraw monthly")
+	flag.IntVar(&o.maxGap, "max-gap", 0, "a period is N/A if a run boundary is more than this many calendar days from the closest day with data, 0 is no limit")
+	flag.Float64Var(&o.dividendYield, "dividend-yield", 0, "annual dividend yield reinvested in stocks, e.g. 0.02, to approximate total return of prices without dividends")
+	flag.Var(&o.phases, "phase", "years:cost, cost per year of some years of a period instead of -l, repeat it for phases in order, -l is the cost after them")
+	flag.IntVar(&o.income, "income", 0, "guaranteed income per year like social security or a pension, inflation adjusted, it reduces cost of living")
+	flag.IntVar(&o.accumulateYears, "accumulate", 0, "years of saving before runs begin, -contribution is invested at the end of each of them")
+	flag.Var(&o.contribution, "contribution", "inflation adjusted contribution per year during -accumulate years, a suffix k, M or B multiplies it")
+	flag.IntVar(&o.incomeStartYear, "income-start-year", 0, "year of a period -income starts from, 0 is the first year")
+	flag.BoolVar(&o.showProgress, "progress", false, "print percent done, elapsed time and eta of checking to stderr")
+	flag.StringVar(&o.outPath, "out", "", "write result of every start day to this csv")
+	flag.BoolVar(&o.showIRR, "irr", false, "also print the distribution of the annual internal rate of return of completed start days, of the initial capital invested, withdrawals received and the portfolio left at the end")
+	flag.StringVar(&o.ledgerPath, "ledger", "", "write every sale of the period starting on -start to this csv, with date, shares sold, price, proceeds and shares held after it")
+	flag.StringVar(&o.trajectoryPath, "trajectory", "", "write value, shares and cash of the portfolio at every run boundary of every start day to this csv, narrow start days with -start and -end for one scenario")
+	flag.StringVar(&o.dateColumn, "date-col", "Date", "name of the date column of price csv")
+	flag.StringVar(&o.priceColumn, "price-col", "", "name of the only price column of price csv, e.g. Price, by default Open, High, Low, Close and Adj Close are read and -price picks one")
+	flag.IntVar(&o.dateIndex, "date-index", 0, "index of the date column of price csv counted from 0, setting it or -price-index reads columns by position instead of -date-col and -price-col")
+	flag.IntVar(&o.priceIndex, "price-index", 2, "index of the only price column of price csv counted from 0, the default is High of yahoo finance, see -date-index")
+	flag.StringVar(&o.delim, "delim", ",", "field separator of price csv, e.g. ; for european exports, \\t for tab")
+	flag.StringVar(&o.decimal, "decimal", ".", "decimal separator of prices, e.g. , for european exports")
+	flag.BoolVar(&o.noHeader, "no-header", false, "price csv has no header, the first row is data and columns are Date, Open, High, Low, Close, Adj Close in order")
+	flag.StringVar(&o.missing, "missing", "skip", "what to do with rows of null or empty prices, skip them or carry prices of the previous row")
+	flag.Float64Var(&o.minRate, "min-rate", 0, "exit with code 1 if successful rate is below this, e.g. 0.95")
+	flag.StringVar(&o.sweepCost, "sweep-cost", "", "check every cost per year in min:max:step like 10k:20k:1k instead of -l and print successful rate of each, the highest one meeting -min-rate is marked")
+	flag.StringVar(&o.sweepCapital, "sweep-capital", "", "check every capital in min:max:step like 200k:500k:50k instead of -c, with the costs of -sweep-cost or -l, and print a csv grid of successful rate")
+	flag.BoolVar(&o.showStats, "stats", false, "print growth rate, max drawdown and longest recovery of the price series before checking")
+	flag.BoolVar(&o.validateOnly, "validate", false, "only check input data, print its date range, rows, gaps longer than -max-gap or a week, order and price range")
+	flag.StringVar(&o.capitalRange, "capital-range", "", "min:max like 200k:500k, check -trials of a random capital in the range from a random start day, and print successful rate by capital")
+	flag.IntVar(&o.trials, "trials", 1000, "how many trials of -capital-range, drawn by -seed")
+	flag.Float64Var(&o.solveCost, "solve-cost", 0, "find the highest cost per year with successful rate of at least this, e.g. 0.95, instead of checking -l")
+	flag.StringVar(&o.compareName, "compare", "", "also check this strategy with the same flags on the same data, and print results of both side by side")
+	flag.BoolVar(&o.partial, "partial", false, "check the last run of a start day until data ends instead of leaving the start day N/A, with cost of living in proportion to the part of the run")
+	flag.BoolVar(&o.naAsFail, "na-as-fail", false, "count N/A start days as failed in successful rate instead of leaving them out, conservative for data too short for some start days")
+	flag.BoolVar(&o.swr, "swr", false, "find the safe withdrawal rate, the highest cost per year as a percentage of -c with every completed start day successful, instead of checking -l, with -strategy constant unless it's set")
+	flag.StringVar(&o.anniversaryDay, "anniversary", "", "mm-dd, put run boundaries on this day of a year like 01-01 instead of anniversaries of each start day, the first run ends -y years after the first such day so it's up to a year longer, a day without data is the closest day with data, the earlier one on a tie")
+	flag.BoolVar(&o.perpetual, "perpetual", false, "keep running every period until data ends instead of -r runs, and print years survived before failing, success is surviving the whole data")
+	flag.Float64Var(&o.crash, "crash", 0, "also print successful rate of start days with a drawdown of at least this much like 0.3 within -crash-years after them versus the others, the sequence of returns risk")
+	flag.IntVar(&o.crashYears, "crash-years", 5, "years after a start day a drawdown of -crash counts as early")
+	flag.StringVar(&o.tableFlag, "table", "auto", "how tables of -bucket-years, -compare, -sweep-cost, -sweep-capital and others are printed, aligned columns, tsv of tab separated values for other programs (csv for -sweep-capital grids), or auto which is aligned on a terminal and tsv otherwise")
+	flag.IntVar(&o.bucketYears, "bucket-years", 0, "also print successful rate of start days grouped by this many years, e.g. 10 for decades")
+	flag.StringVar(&o.downsample, "resample", "", "keep only the last day of each week or month of prices, weekly or monthly, for quick exploration, results differ from daily prices")
+	flag.BoolVar(&o.sortDates, "sort", false, "sort input by date instead of failing on unsorted input")
+	flag.StringVar(&o.configPath, "config", "", "json file of flag values like {\"c\": \"1.5M\", \"strategy\": \"percent\", \"phase\": [\"10:30000\"]}, flags on the command line override it")
+	flag.Parse()
+	if o.configPath != "" {
+		if err := loadFlags(o.configPath); err != nil {
+			return nil, fmt.Errorf("%s: %w", o.configPath, err)
+		}
+	}
+	o.set = map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		o.set[f.Name] = true
+	})
+	return o, nil
+}
+
+// validate checks flags which don't need data, and parses ranges, dates and strategies of them
+func (o *options) validate() error {
+	if o.horizonYears != 0 {
+		if o.set["r"] {
+			return errors.New("-horizon sets how many runs there are, it can't be used with -r")
+		}
+		if !o.set["y"] {
+			o.yearPerRun = 1
+		}
+		if o.horizonYears < 0 || o.yearPerRun <= 0 || o.horizonYears%o.yearPerRun != 0 {
+			return fmt.Errorf("invalid horizon %d, it must be a positive multiple of -y %d", o.horizonYears, o.yearPerRun)
+		}
+		o.runs = o.horizonYears / o.yearPerRun
+	}
+	if o.format != "text" && o.format != "json" && o.format != "markdown" && o.format != "jsonl" {
+		return fmt.Errorf("unknown format %s", o.format)
+	}
+	if o.workers < 1 {
+		return fmt.Errorf("invalid worker count %d", o.workers)
+	}
+	if o.runs < 1 {
+		return fmt.Errorf("invalid run count %d, it must be at least 1", o.runs)
+	}
+	if o.yearPerRun < 1 {
+		return fmt.Errorf("invalid years per run %d, it must be at least 1", o.yearPerRun)
+	}
+	if o.quiet && (o.verbose || o.traceFile != "" || o.logLevel != "info" || o.format != "text" || o.showStats || o.bucketYears > 0 ||
+		o.sweepCost != "" || o.sweepCapital != "" || o.capitalRange != "" || o.compareName != "") {
+		return errors.New("-quiet prints only one number, it can't be used with -v, -log-level, -trace-file, -format, -stats, -bucket-years, -sweep-cost, -sweep-capital, -capital-range or -compare")
+	}
+	// 0.016 for 1.6% would shrink every target to almost nothing and succeed everywhere
+	if o.inflationRate <= 0 || (o.inflationRate < 1 && !o.allowDeflation) {
+		return fmt.Errorf("invalid inflation rate %g, it's 1 + rate like 1.016 for 1.6%%, use -allow-deflation for a rate below 1", o.inflationRate)
+	}
+	if o.costBasis != "average" && o.costBasis != "fifo" {
+		return fmt.Errorf("unknown cost basis %s, expect average or fifo", o.costBasis)
+	}
+	if o.reserveYears < 0 {
+		return fmt.Errorf("invalid reserve years %g", o.reserveYears)
+	}
+	if o.accumulateYears < 0 {
+		return fmt.Errorf("invalid accumulation years %d", o.accumulateYears)
+	}
+	if o.taxRate < 0 || o.taxRate >= 1 {
+		return fmt.Errorf("invalid tax rate %f", o.taxRate)
+	}
+	if o.swr {
+		// a safe withdrawal rate is how much is withdrawn without running out of money
+		if !o.set["strategy"] {
+			o.strategyName = "constant"
+		}
+		if o.strategyName == "fixed" {
+			return errors.New("-swr can't use -strategy fixed, it fails once capital can't grow back to the inflation adjusted principal however low the cost is, use constant, percent or guardrails")
+		}
+	}
+	var err error
+	if o.withdrawStrategy, err = newStrategy(o.strategyName, o.targetMargin, o.percent, o.floor, o.ceiling, o.guardrail, o.guardrailAdjust); err != nil {
+		return err
+	}
+	if o.compareName != "" {
+		if o.compareName == o.strategyName {
+			return fmt.Errorf("-compare %s is the same as -strategy", o.compareName)
+		}
+		if o.compareStrategy, err = newStrategy(o.compareName, o.targetMargin, o.percent, o.floor, o.ceiling, o.guardrail, o.guardrailAdjust); err != nil {
+			return err
+		}
+	}
+	if o.withdrawFrom != "proportional" && o.withdrawFrom != "bonds" {
+		return fmt.Errorf("unknown withdraw-from %s", o.withdrawFrom)
+	}
+	if o.withdrawMode != "lump" && o.withdrawMode != "monthly" {
+		return fmt.Errorf("unknown withdraw %s", o.withdrawMode)
+	}
+	if o.income < 0 || o.incomeStartYear < 0 {
+		return fmt.Errorf("invalid income %d from year %d", o.income, o.incomeStartYear)
+	}
+	if o.dividendYield < 0 || o.dividendYield >= 1 {
+		return fmt.Errorf("invalid dividend yield %f", o.dividendYield)
+	}
+	if o.cashAlloc < 0 || o.cashAlloc >= 1 {
+		return fmt.Errorf("invalid cash allocation %f", o.cashAlloc)
+	}
+	if o.sweepCost != "" {
+		if o.sweepCosts, err = parseRange(o.sweepCost); err != nil {
+			return err
+		}
+	}
+	if o.sweepCapital != "" {
+		if o.sweepCapitals, err = parseRange(o.sweepCapital); err != nil {
+			return err
+		}
+		if o.sweepCosts == nil {
+			o.sweepCosts = []int64{int64(o.costPerYear)}
+		}
+	}
+	if o.capitalRange != "" {
+		bounds, err := parseAmounts(o.capitalRange, 2)
+		if err != nil || bounds[0] <= 0 || bounds[0] > bounds[1] || o.trials <= 0 {
+			return fmt.Errorf("invalid capital range %q or trials %d, expect min:max like 200000:500000 or 200k:500k", o.capitalRange, o.trials)
+		}
+		o.capitalLow, o.capitalHigh = bounds[0], bounds[1]
+	}
+	if o.sellPrice != "" {
+		o.priceField = o.sellPrice
+	}
+	for _, field := range []string{o.priceField, o.buyPrice} {
+		if field != "" && !rearview.IsPriceField(field) {
+			return fmt.Errorf("unknown price %s", field)
+		}
+	}
+
+	if o.perpetual && (o.monteCarlo > 0 || o.capitalRange != "") {
+		return errors.New("-perpetual runs until historical data ends, it can't be used with -montecarlo or -capital-range")
+	}
+	if o.startDays != "" {
+		if o.monteCarlo > 0 || o.capitalRange != "" {
+			return errors.New("-starts checks historical start days, it can't be used with -montecarlo or -capital-range")
+		}
+		for _, value := range strings.Split(o.startDays, ",") {
+			start, err := parseDate(strings.TrimSpace(value))
+			if err != nil || start.IsZero() {
+				return fmt.Errorf("invalid start date %q in -starts, expect yyyy-mm-dd", value)
+			}
+			o.starts = append(o.starts, start)
+		}
+	}
+	if o.crash < 0 || o.crash >= 1 || o.crashYears <= 0 {
+		return fmt.Errorf("invalid drawdown %g or years %d, expect a drawdown in [0, 1) like 0.3 and positive years", o.crash, o.crashYears)
+	}
+	if o.format == "jsonl" && (o.outPath != "" || o.trajectoryPath != "" || o.ledgerPath != "" || o.bucketYears > 0 || o.crash > 0 || o.capitalRange != "") {
+		return errors.New("-format jsonl streams periods instead of keeping them, it can't be used with -out, -trajectory, -ledger, -bucket-years, -crash or -capital-range")
+	}
+	if o.crash > 0 && (o.monteCarlo > 0 || o.capitalRange != "") {
+		return errors.New("-crash checks drawdowns after historical start days, it can't be used with -montecarlo or -capital-range")
+	}
+	if o.ledgerPath != "" && (o.startDate == "" || o.monteCarlo > 0 || o.capitalRange != "") {
+		return errors.New("-ledger writes sales of the period starting on -start, it needs -start and can't be used with -montecarlo or -capital-range")
+	}
+	return nil
+}
+
+// newConfig is the plan of flags, with cpi and spending schedule read from their files
+func (o *options) newConfig() (rearview.Config, error) {
+	stockWeight, err := parseAllocation(o.alloc)
+	if err != nil {
+		return rearview.Config{}, err
+	}
+	config := rearview.Config{
+		Capital:         int64(o.capital),
+		Run:             o.runs,
+		YearPerRun:      o.yearPerRun,
+		InflationRate:   o.inflationRate,
+		CostPerYear:     int(o.costPerYear),
+		PriceField:      o.priceField,
+		BuyPriceField:   o.buyPrice,
+		Workers:         o.workers,
+		TaxRate:         o.taxRate,
+		FIFO:            o.costBasis == "fifo",
+		Fee:             o.tradeFee,
+		WholeShares:     o.wholeShares,
+		Strategy:        o.withdrawStrategy,
+		StockWeight:     stockWeight,
+		BondsFirst:      o.withdrawFrom == "bonds",
+		CashWeight:      o.cashAlloc,
+		CashReturn:      o.cashReturn,
+		ReserveYears:    o.reserveYears,
+		Monthly:         o.withdrawMode == "monthly",
+		Pessimistic:     o.pessimistic,
+		MaxGap:          o.maxGap,
+		DividendYield:   o.dividendYield,
+		Phases:          o.phases,
+		Income:          o.income,
+		IncomeStartYear: o.incomeStartYear,
+		AccumulateYears: o.accumulateYears,
+		Contribution:    int(o.contribution),
+		Perpetual:       o.perpetual,
+		RealTrace:       o.realTrace,
+		Trajectory:      o.trajectoryPath != "",
+		Ledger:          o.ledgerPath != "",
+		IRR:             o.showIRR,
+		NAAsFailed:      o.naAsFail,
+		Partial:         o.partial,
+	}
+	if o.anniversaryDay != "" {
+		anniversary, err := time.Parse("01-02", o.anniversaryDay)
+		if err != nil {
+			return config, fmt.Errorf("invalid anniversary %q, expect mm-dd like 01-01", o.anniversaryDay)
+		}
+		config.AnniversaryMonth, config.AnniversaryDay = anniversary.Month(), anniversary.Day()
+	}
+
+	if o.cpiPath != "" {
+		if config.CPI, err = readCPI(o.cpiPath); err != nil {
+			return config, err
+		}
+	}
+	if o.spendingPath != "" {
+		if config.Spending, err = readSpending(o.spendingPath); err != nil {
+			return config, err
+		}
+		if years := config.Run * config.YearPerRun; len(config.Spending) < years {
+			fmt.Fprintf(os.Stderr, "spending schedule covers %d of %d years, later years use the last multiplier\n", len(config.Spending), years)
+		}
+	}
+	return config, nil
+}
+
+// csvOptions are how price csv is read
+func (o *options) csvOptions() (rearview.CSVOptions, error) {
+	// -validate reports the order itself
+	csvOptions := rearview.CSVOptions{DateColumn: o.dateColumn, PriceColumn: o.priceColumn, NoHeader: o.noHeader, Unsorted: o.sortDates || o.validateOnly}
+	// close can't stand in for adjusted close which is asked for
+	csvOptions.CloseAsAdjClose = o.priceField != "adjclose" && o.buyPrice != "adjclose"
+	if o.set["date-index"] || o.set["price-index"] {
+		if o.set["date-col"] || o.set["price-col"] {
+			fmt.Fprintf(os.Stderr, "-date-index and -price-index are used, -date-col and -price-col are ignored\n")
+		}
+		csvOptions.ByIndex, csvOptions.DateIndex, csvOptions.PriceIndex = true, o.dateIndex, o.priceIndex
+	}
+	var err error
+	if csvOptions.Comma, err = parseSeparator(o.delim); err != nil {
+		return csvOptions, fmt.Errorf("invalid -delim: %w", err)
+	}
+	if csvOptions.Decimal, err = parseSeparator(o.decimal); err != nil {
+		return csvOptions, fmt.Errorf("invalid -decimal: %w", err)
+	}
+	if csvOptions.Comma == csvOptions.Decimal {
+		return csvOptions, errors.New("-delim and -decimal must be different")
+	}
+	switch o.missing {
+	case "skip":
+	case "carry":
+		csvOptions.CarryMissing = true
+	default:
+		return csvOptions, fmt.Errorf("unknown -missing %s", o.missing)
+	}
+	return csvOptions, nil
+}
+
+// parseRange parses min:max:step into min, min+step, ... up to max
+func parseRange(value string) ([]int64, error) {
+	parts, err := parseAmounts(value, 3)
+	if err != nil || parts[2] <= 0 || parts[0] > parts[1] {
+		return nil, fmt.Errorf("invalid range %q, expect min:max:step like 10000:30000:1000 or 10k:30k:1k", value)
+	}
+	low, high, step := parts[0], parts[1], parts[2]
+	values := []int64{}
+	for v := low; v <= high; v += step {
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func newStrategy(name string, margin, percent float64, floor, ceiling int, guardrail, guardrailAdjust float64) (rearview.Strategy, error) {
+	switch name {
+	case "fixed":
+		if margin <= 0 {
+			return nil, fmt.Errorf("invalid target margin %f", margin)
+		}
+		if margin == 1 {
+			return rearview.FixedStrategy{}, nil
+		}
+		return rearview.FixedStrategy{Margin: margin}, nil
+	case "constant":
+		return rearview.ConstantStrategy{}, nil
+	case "percent":
+		if percent <= 0 || percent >= 1 {
+			return nil, fmt.Errorf("invalid percent %f", percent)
+		}
+		if floor < 0 || ceiling < 0 || (ceiling > 0 && ceiling < floor) {
+			return nil, fmt.Errorf("invalid floor %d or ceiling %d", floor, ceiling)
+		}
+		return rearview.PercentStrategy{Rate: percent, Floor: floor, Ceiling: ceiling}, nil
+	case "guardrails":
+		if percent <= 0 || percent >= 1 {
+			return nil, fmt.Errorf("invalid percent %f", percent)
+		}
+		if guardrail <= 0 || guardrail >= 1 || guardrailAdjust <= 0 || guardrailAdjust >= 1 {
+			return nil, fmt.Errorf("invalid guardrail %f or guardrail adjust %f", guardrail, guardrailAdjust)
+		}
+		return rearview.GuardrailsStrategy{Rate: percent, Band: guardrail, Adjust: guardrailAdjust}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %s", name)
+	}
+}
+
+// parseSeparator parses one character, \t is tab
+func parseSeparator(value string) (rune, error) {
+	if value == `\t` {
+		return '\t', nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("%q is not one character", value)
+	}
+	return runes[0], nil
+}
+
+// loadFlags sets flags from a json object of flag names and values in path,
+// a list sets a repeatable flag like -phase once per value,
+// flags already set on the command line are kept
+func loadFlags(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(content, &values); err != nil {
+		return err
+	}
+	setOnCommandLine := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	// the same error every time for a file with several problems
+	sort.Strings(names)
+	for _, name := range names {
+		if flag.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown flag %q", name)
+		}
+		if setOnCommandLine[name] {
+			continue
+		}
+		list, ok := values[name].([]interface{})
+		if !ok {
+			list = []interface{}{values[name]}
+		}
+		for _, value := range list {
+			text := ""
+			switch value := value.(type) {
+			case string:
+				text = value
+			case float64:
+				text = strconv.FormatFloat(value, 'f', -1, 64)
+			case bool:
+				text = strconv.FormatBool(value)
+			default:
+				return fmt.Errorf("flag %q: value %v is not a string, number or bool", name, value)
+			}
+			if err := flag.Set(name, text); err != nil {
+				return fmt.Errorf("flag %q: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// amountFlag is dollars with an optional suffix multiplier like 333k or 1.5M
+type amountFlag int64
+
+var amountSuffixes = map[string]float64{"k": 1e3, "m": 1e6, "b": 1e9}
+
+func (f *amountFlag) String() string {
+	return strconv.FormatInt(int64(*f), 10)
+}
+
+func (f *amountFlag) Set(value string) error {
+	number, multiplier := value, float64(1)
+	if i := strings.IndexFunc(value, unicode.IsLetter); i >= 0 {
+		number = value[:i]
+		if multiplier = amountSuffixes[strings.ToLower(value[i:])]; multiplier == 0 {
+			return fmt.Errorf("invalid amount %q, suffix %q is not k, M or B", value, value[i:])
+		}
+	}
+	amount, err := strconv.ParseFloat(number, 64)
+	if err != nil || amount < 0 {
+		return fmt.Errorf("invalid amount %q, expect a non-negative number with an optional suffix k, M or B like 333k", value)
+	}
+	*f = amountFlag(math.Round(amount * multiplier))
+	return nil
+}
+
+// parseAmounts parses n amounts of amountFlag separated by colons like 200k:500k
+func parseAmounts(value string, n int) ([]int64, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != n {
+		return nil, fmt.Errorf("%q is not %d amounts separated by colons", value, n)
+	}
+	amounts := make([]int64, n)
+	for i, part := range parts {
+		amount := amountFlag(0)
+		if err := amount.Set(part); err != nil {
+			return nil, err
+		}
+		amounts[i] = int64(amount)
+	}
+	return amounts, nil
+}
+
+// fileFlag is repeated -f, the first -f replaces the default path
+type fileFlag struct {
+	paths []string
+	set   bool
+}
+
+func (f *fileFlag) String() string {
+	return strings.Join(f.paths, " ")
+}
+
+func (f *fileFlag) Set(value string) error {
+	if !f.set {
+		f.paths, f.set = nil, true
+	}
+	f.paths = append(f.paths, value)
+	return nil
+}
+
+// phaseFlag is repeated -phase years:cost
+type phaseFlag []rearview.Phase
+
+func (f *phaseFlag) String() string {
+	phases := []string{}
+	for _, phase := range *f {
+		phases = append(phases, fmt.Sprintf("%d:%d", phase.Years, phase.CostPerYear))
+	}
+	return strings.Join(phases, ",")
+}
+
+func (f *phaseFlag) Set(value string) error {
+	phase := rearview.Phase{}
+	if _, err := fmt.Sscanf(value, "%d:%d", &phase.Years, &phase.CostPerYear); err != nil || phase.Years <= 0 || phase.CostPerYear < 0 {
+		return fmt.Errorf("invalid phase %q, expect years:cost like 20:30000", value)
+	}
+	*f = append(*f, phase)
+	return nil
+}
+
+// parseAllocation parses stocks/bonds like 60/40 into weight of stocks
+func parseAllocation(value string) (float64, error) {
+	stocks, bonds := 0.0, 0.0
+	if _, err := fmt.Sscanf(value, "%g/%g", &stocks, &bonds); err != nil || stocks < 0 || bonds < 0 || stocks+bonds == 0 {
+		return 0, fmt.Errorf("invalid allocation %q, expect stocks/bonds like 60/40", value)
+	}
+	return stocks / (stocks + bonds), nil
+}
+
+// parseDate parses yyyy-mm-dd, empty value is zero time
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
@@ -0,0 +1,3 @@
+module github.com/aaron0x/rearview
+
+go 1.18
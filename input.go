@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aaron0x/rearview/rearview"
+)
+
+// readInput reads prices of -ticker or -f
+func (o *options) readInput(csvOptions rearview.CSVOptions) ([]rearview.DatePrice, error) {
+	var datePrices []rearview.DatePrice
+	var err error
+	if o.ticker != "" {
+		datePrices, err = fetchPrices(o.source, o.ticker, o.timeout, csvOptions)
+	} else {
+		datePrices, err = readBlend(o.files.paths, o.sortDates, csvOptions)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(datePrices) == 0 {
+		return nil, errors.New("no input data")
+	}
+	return datePrices, nil
+}
+
+// prepare sorts and checks the order of datePrices, reads -bonds into config,
+// and narrows datePrices to -start and -end and days of -resample
+func (o *options) prepare(config *rearview.Config, datePrices []rearview.DatePrice, csvOptions rearview.CSVOptions) ([]rearview.DatePrice, error) {
+	if o.sortDates {
+		rearview.SortByDate(datePrices)
+	}
+	if err := rearview.ValidateDateOrder(datePrices); err != nil {
+		if o.ticker != "" {
+			return nil, fmt.Errorf("%s: %w", o.ticker, err)
+		}
+		return nil, fmt.Errorf("%s: %w", strings.Join(o.files.paths, ", "), err)
+	}
+	if o.bondsPath != "" {
+		bonds, err := readPrices(o.bondsPath, csvOptions)
+		if err != nil {
+			return nil, err
+		}
+		if len(bonds) == 0 {
+			return nil, errors.New("no bond data")
+		}
+		if o.sortDates {
+			rearview.SortByDate(bonds)
+		}
+		if err := rearview.ValidateDateOrder(bonds); err != nil {
+			return nil, fmt.Errorf("%s: %w", o.bondsPath, err)
+		}
+		config.Bonds = bonds
+	}
+	if o.startDate != "" || o.endDate != "" {
+		start, err := parseDate(o.startDate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start date: %w", err)
+		}
+		end, err := parseDate(o.endDate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end date: %w", err)
+		}
+		datePrices = rearview.SliceDateRange(datePrices, start, end)
+		years := config.AccumulateYears + config.Run*config.YearPerRun
+		if len(datePrices) == 0 || (!o.partial && datePrices[len(datePrices)-1].Date.Before(datePrices[0].Date.AddDate(years, 0, 0))) {
+			return nil, fmt.Errorf("date range is shorter than %s, nothing to test", horizon(config))
+		}
+	}
+
+	switch o.downsample {
+	case "":
+	case "weekly":
+		datePrices = rearview.DownsampleWeekly(datePrices)
+	case "monthly":
+		datePrices = rearview.DownsampleMonthly(datePrices)
+	default:
+		return nil, fmt.Errorf("unknown -resample %s", o.downsample)
+	}
+	return datePrices, nil
+}
+
+// readCPI reads the cpi csv of path
+func readCPI(path string) ([]rearview.DateCPI, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	cpi, err := rearview.ParseCPI(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(cpi) == 0 {
+		return nil, errors.New("no cpi data")
+	}
+	return cpi, nil
+}
+
+// readSpending reads the spending schedule csv of path
+func readSpending(path string) ([]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	spending, err := rearview.ParseSpending(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(spending) == 0 {
+		return nil, errors.New("no spending data")
+	}
+	return spending, nil
+}
+
+// validatePrices prints a summary of datePrices and problems found in them, it returns false if they are not sorted
+func validatePrices(config *rearview.Config, datePrices []rearview.DatePrice, gapDays int, logger rearview.Logger) bool {
+	orderErr := rearview.ValidateDateOrder(datePrices)
+	sorted := append([]rearview.DatePrice{}, datePrices...)
+	rearview.SortByDate(sorted)
+
+	low, high := sorted[0], sorted[0]
+	for _, datePrice := range sorted {
+		if datePrice.LowPrice < low.LowPrice {
+			low = datePrice
+		}
+		if datePrice.HighPrice > high.HighPrice {
+			high = datePrice
+		}
+	}
+	logger.Printf("%d rows from %s to %s\n", len(sorted), toyyyymmdd(sorted[0].Date), toyyyymmdd(sorted[len(sorted)-1].Date))
+	logger.Printf("lowest price %f on %s, highest price %f on %s\n", low.LowPrice, toyyyymmdd(low.Date), high.HighPrice, toyyyymmdd(high.Date))
+	if orderErr != nil {
+		logger.Printf("not sorted, %v\n", orderErr)
+	} else {
+		logger.Printf("sorted by date\n")
+	}
+
+	gaps := rearview.FindGaps(sorted, gapDays)
+	logger.Printf("%d gaps longer than %d days\n", len(gaps), gapDays)
+	for i, gap := range gaps {
+		if i == 10 {
+			logger.Printf("...\n")
+			break
+		}
+		logger.Printf("%s to %s, %d days\n", toyyyymmdd(gap.From), toyyyymmdd(gap.To), gap.Days)
+	}
+	return orderErr == nil
+}
+
+// fetchPrices downloads daily prices of ticker from source
+func fetchPrices(source, ticker string, timeout time.Duration, options rearview.CSVOptions) ([]rearview.DatePrice, error) {
+	if source != "stooq" {
+		return nil, fmt.Errorf("unknown source %s", source)
+	}
+	client := http.Client{Timeout: timeout}
+	link := "https://stooq.com/q/d/l/?i=d&s=" + url.QueryEscape(strings.ToLower(ticker))
+	response, err := client.Get(link)
+	if err != nil {
+		return nil, fmt.Errorf("can't download %s from stooq: %w", ticker, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't download %s from stooq: %s", ticker, response.Status)
+	}
+
+	// stooq's own csv format, whatever the flags of local files are
+	options.Comma, options.Decimal, options.DateColumn, options.ByIndex = ',', '.', "", false
+	datePrices, err := rearview.ParseCSV(response.Body, options)
+	if err != nil {
+		// stooq answers an unknown ticker with a page of "No data"
+		return nil, fmt.Errorf("stooq has no valid data of %s: %w", ticker, err)
+	}
+	return datePrices, nil
+}
+
+// readPriceFiles reads comma separated paths or globs of price csv into one series by date,
+// a date in more than one file takes prices of the last file
+func readPriceFiles(paths string, options rearview.CSVOptions) ([]rearview.DatePrice, error) {
+	if !strings.Contains(paths, ",") && !strings.ContainsAny(paths, "*?[") {
+		return readPrices(paths, options)
+	}
+
+	files := []string{}
+	for _, pattern := range strings.Split(paths, ",") {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %s: %w", pattern, err)
+		}
+		if matches == nil {
+			return nil, fmt.Errorf("no file matches %s", pattern)
+		}
+		files = append(files, matches...)
+	}
+	series := [][]rearview.DatePrice{}
+	for _, file := range files {
+		datePrices, err := readPrices(file, options)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, datePrices)
+	}
+	datePrices, conflicts := rearview.MergeSeries(series...)
+	if len(conflicts) > 0 {
+		fmt.Fprintf(os.Stderr, "%d dates have different prices in %s, the last file wins, the first one is %s\n", len(conflicts), strings.Join(files, ", "), toyyyymmdd(conflicts[0]))
+	}
+	return datePrices, nil
+}
+
+// readBlend reads prices of -f, a path:weight in paths makes them a blend of every path by its weight
+func readBlend(paths []string, sortDates bool, options rearview.CSVOptions) ([]rearview.DatePrice, error) {
+	if len(paths) == 1 {
+		if _, _, weighted := splitWeight(paths[0]); !weighted {
+			return readPriceFiles(paths[0], options)
+		}
+	}
+
+	series, weights := [][]rearview.DatePrice{}, []float64{}
+	for _, value := range paths {
+		path, weight, weighted := splitWeight(value)
+		if !weighted {
+			return nil, fmt.Errorf("-f %s has no weight, every -f needs path:weight to blend them", value)
+		}
+		datePrices, err := readPriceFiles(path, options)
+		if err != nil {
+			return nil, err
+		}
+		if sortDates {
+			rearview.SortByDate(datePrices)
+		}
+		if err := rearview.ValidateDateOrder(datePrices); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		series, weights = append(series, datePrices), append(weights, weight)
+	}
+	blended, err := rearview.BlendSeries(series, weights)
+	if err != nil {
+		return nil, fmt.Errorf("can't blend %s: %w", strings.Join(paths, ", "), err)
+	}
+	if len(series) > 1 {
+		fmt.Fprintf(os.Stderr, "blended prices are from %s to %s, the days all files have\n", toyyyymmdd(blended[0].Date), toyyyymmdd(blended[len(blended)-1].Date))
+	}
+	return blended, nil
+}
+
+// splitWeight splits path:weight of -f, a path without a number after its last colon has no weight
+func splitWeight(value string) (string, float64, bool) {
+	i := strings.LastIndex(value, ":")
+	if i < 0 {
+		return value, 0, false
+	}
+	weight, err := strconv.ParseFloat(value[i+1:], 64)
+	if err != nil {
+		return value, 0, false
+	}
+	return value[:i], weight, true
+}
+
+// readPrices reads price csv at path, - is stdin, gzip compressed csv is decompressed
+func readPrices(path string, options rearview.CSVOptions) ([]rearview.DatePrice, error) {
+	input := io.Reader(os.Stdin)
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	input, gzipped, err := gunzipIfNeeded(input)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid gzip file: %w", path, err)
+	}
+
+	headers := []string{}
+	options.OnRepeatedHeader = func(line int) {
+		headers = append(headers, strconv.Itoa(line))
+	}
+	datePrices, err := rearview.ParseCSV(input, options)
+	if err != nil {
+		if gzipped {
+			return nil, fmt.Errorf("can't read gzip file %s, it may be truncated or corrupt: %w", path, err)
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(headers) > 0 {
+		lines := "line"
+		if len(headers) > 1 {
+			lines += "s"
+		}
+		fmt.Fprintf(os.Stderr, "%s: skipped column names again on %s %s, like files pasted together\n", path, lines, strings.Join(headers, ", "))
+	}
+	return datePrices, nil
+}
+
+// gunzipIfNeeded decompresses input if it starts with gzip magic bytes,
+// so both .csv and .csv.gz files are accepted
+func gunzipIfNeeded(input io.Reader) (io.Reader, bool, error) {
+	buffered := bufio.NewReader(input)
+	magic, err := buffered.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return buffered, false, nil
+	}
+	gzipReader, err := gzip.NewReader(buffered)
+	if err != nil {
+		return nil, true, err
+	}
+	return gzipReader, true, nil
+}
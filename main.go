@@ -1,220 +1,350 @@
 package main
 
 import (
-	"encoding/csv"
-	"flag"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"math"
 	"os"
-	"sort"
+	"os/signal"
+	"strings"
 	"time"
-)
-
-type datePrice struct {
-	Date      time.Time
-	HighPrice float64
-}
 
-type logger struct {
-	verbose bool
-}
+	"github.com/aaron0x/rearview/rearview"
+)
 
-func (l *logger) Tracef(format string, v ...interface{}) {
-	if l.verbose {
-		fmt.Printf(format, v...)
+func main() {
+	if err := run(); err != nil {
+		if err != errExit {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
 	}
 }
 
-func (l *logger) Printf(format string, v ...interface{}) {
-	fmt.Printf(format, v...)
-}
+// errExit fails run after the reason is printed
+var errExit = errors.New("exit 1")
 
-func newLogger(verbose bool) *logger {
-	return &logger{
-		verbose,
+// run checks the plan of flags, it returns an error of bad input or a check not passed
+func run() error {
+	o, err := parseFlags()
+	if err != nil {
+		return err
 	}
-}
-
-type config struct {
-	capital       int64
-	run           int
-	yearPerRun    int
-	inflationRate float64
-	costPerYear   int
-}
-
-func main() {
-	verbose := flag.Bool("v", false, "show verbose progress")
-	capital := flag.Int64("c", 333333, "initial capital")
-	filePath := flag.String("f", "./GSPC.csv", "input csv path")
-	run := flag.Int("r", 5, "how many runs to test")
-	yearPerRun := flag.Int("y", 10, "how many years in one run")
-	inflationRate := flag.Float64("i", 1.016, "inflation rate")
-	costPerYear := flag.Int("l", 16666, "cost per year")
-	flag.Parse()
-
-	logger := newLogger(*verbose)
-	config := config{
-		capital:       *capital,
-		run:           *run,
-		yearPerRun:    *yearPerRun,
-		inflationRate: *inflationRate,
-		costPerYear:   *costPerYear,
-	}
-
-	file, err := os.Open(*filePath)
+	if err := o.validate(); err != nil {
+		return err
+	}
+	config, err := o.newConfig()
+	if err != nil {
+		return err
+	}
+	c := &checker{options: o, config: config}
+	closeTrace, err := c.newLogger()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer file.Close()
+	defer closeTrace()
 
-	datePrices, err := parseCSVFile(file)
+	csvOptions, err := o.csvOptions()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	datePrices, err := o.readInput(csvOptions)
+	if err != nil {
+		return err
+	}
+	if o.validateOnly {
+		gapDays := o.maxGap
+		if gapDays == 0 {
+			gapDays = 7
+		}
+		if !validatePrices(&c.config, datePrices, gapDays, c.logger) {
+			return errExit
+		}
+		return nil
 	}
-	if len(datePrices) == 0 {
-		panic("no input data")
+	if c.datePrices, err = o.prepare(&c.config, datePrices, csvOptions); err != nil {
+		return err
+	}
+	if o.showStats {
+		if err := printStats(rearview.Stats(&c.config, c.datePrices), o.format, c.logger); err != nil {
+			return err
+		}
+	}
+	c.warnShortData()
+	if o.monteCarlo > 0 && len(c.datePrices) < 2 {
+		return errors.New("monte carlo needs at least 2 days of data")
 	}
 
-	checkStrategy(&config, datePrices, logger)
+	// ctrl-c stops checking and reports start days checked so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	c.ctx = ctx
+	return c.run()
 }
 
-func findClosestDay(day time.Time, inDatePrices []*datePrice) (int, bool) {
-	index := sort.Search(len(inDatePrices), func(i int) bool {
-		datePriceDate := inDatePrices[i].Date
-		return datePriceDate.After(day) || datePriceDate.Equal(day)
-	})
-	if index == len(inDatePrices) {
-		return -1, false
-	}
-	return index, true
+// checker checks the plan of flags on prices in the mode flags ask for
+type checker struct {
+	*options
+	config       rearview.Config
+	datePrices   []rearview.DatePrice
+	logger       rearview.Logger
+	resultWriter io.Writer
+	style        tableStyle
+	progress     *progressLine
+	ctx          context.Context
 }
 
-const (
-	success = iota
-	failed
-	na
-)
-
-func checkStrategy(config *config, datePrices []*datePrice, logger *logger) {
-	successCount, failedCount, naCount := 0, 0, 0
-	for i := range datePrices {
-		r := checkInPeriod(config, datePrices[i:], logger)
-
-		switch r {
-		case success:
-			successCount++
-		case failed:
-			failedCount++
-		case na:
-			naCount++
-		default:
-			panic(fmt.Sprintf("unknow check result %d", r))
+// newLogger sets the logger of -log-level, -trace-file and -format, and how tables are printed,
+// the returned func closes the trace file
+func (c *checker) newLogger() (func(), error) {
+	level, err := rearview.ParseLevel(c.logLevel)
+	if err != nil {
+		return nil, err
+	}
+	if (c.verbose || c.traceFile != "") && level < rearview.LevelTrace {
+		level = rearview.LevelTrace
+	}
+	// stdout is only lines of periods with jsonl
+	c.resultWriter = io.Writer(os.Stdout)
+	if c.format == "jsonl" {
+		c.resultWriter = os.Stderr
+	}
+	traceWriter, closeTrace := c.resultWriter, func() {}
+	if c.traceFile != "" {
+		file, err := os.Create(c.traceFile)
+		if err != nil {
+			return nil, err
 		}
+		// not buffered, traces can be followed as they are written
+		traceWriter, closeTrace = file, func() { file.Close() }
 	}
-	logger.Printf("success %d, failed: %d, N/A: %d, successful rate %f\n", successCount, failedCount, naCount, float64(successCount)/float64(successCount+failedCount))
+	c.logger = rearview.NewTraceLogger(c.resultWriter, traceWriter, level)
+	if c.style, err = parseTableStyle(c.tableFlag, c.resultWriter); err != nil {
+		closeTrace()
+		return nil, err
+	}
+	return closeTrace, nil
 }
 
-func toyyyymmdd(date time.Time) string {
-	return date.Format("2006-01-02")
+// warnShortData warns when prices are too short for any start day to complete
+func (c *checker) warnShortData() {
+	if c.perpetual || c.partial || c.monteCarlo > 0 {
+		return
+	}
+	years := c.config.AccumulateYears + c.config.Run*c.config.YearPerRun
+	first, last := c.datePrices[0].Date, c.datePrices[len(c.datePrices)-1].Date
+	if last.Before(first.AddDate(years, 0, 0)) {
+		fmt.Fprintf(os.Stderr, "%s need %d years of data, prices from %s to %s cover %.1f years, no start day can complete, every one is N/A unless it fails early\n",
+			horizon(&c.config), years, toyyyymmdd(first), toyyyymmdd(last), last.Sub(first).Hours()/24/365.25)
+	}
 }
 
-func checkInPeriod(config *config, datePrices []*datePrice, logger *logger) int {
-	// initial shares
-	heldShares := int64(float64(config.capital) / datePrices[0].HighPrice)
-	logger.Tracef("initial: capital %d, it can buy %d shares\n\n", config.capital, heldShares)
-
-	startDay, endDay := datePrices[0].Date, datePrices[0].Date
-	for run := 0; run < config.run; run++ {
-		// find index of start day and end day in datePrices for this run
-		startDay, endDay = endDay, endDay.AddDate(config.yearPerRun, 0, 0)
-		startIndex, sFound := findClosestDay(startDay, datePrices)
-		endIndex, eFound := findClosestDay(endDay, datePrices)
-		if !sFound || !eFound {
-			logger.Tracef("no more available date to test\n")
-			return na
-		}
-
-		// compute captial after this run and cost of live with inflation considered
-		// add these two then we have target capital in this run
-		inflationRate := math.Pow(config.inflationRate, float64((run+1)*config.yearPerRun))
-		inflationCapital := float64(config.capital) * inflationRate
-		costOfLiving := float64(config.costPerYear) * float64(config.yearPerRun) * inflationRate
-		targetCapital := inflationCapital + costOfLiving
-		logger.Tracef("%s to %s, target capital %d, prepared cost of living %d\n",
-			toyyyymmdd(datePrices[startIndex].Date),
-			toyyyymmdd(datePrices[endIndex].Date),
-			int(targetCapital),
-			int(costOfLiving),
-		)
-
-		satisfied := false
-		for currIndex := startIndex; currIndex < endIndex; currIndex++ {
-			// find one day in this run satisfy our target captial
-			datePrice := datePrices[currIndex]
-			if float64(heldShares)*datePrice.HighPrice >= targetCapital {
-				satisfied = true
-
-				// sold shares to get money ^^
-				soldShares := int64(costOfLiving / datePrice.HighPrice)
-				heldShares -= soldShares
-
-				logger.Tracef("%s sell %d shares in %f, earn %d, remained shares %d\n",
-					toyyyymmdd(datePrice.Date),
-					soldShares,
-					datePrice.HighPrice,
-					int64(float64(soldShares)*datePrice.HighPrice),
-					heldShares,
-				)
-				logger.Tracef("new capital %d\n\n", int(float64(heldShares)*datePrice.HighPrice))
-				break
+// run checks in the mode of flags and reports results
+func (c *checker) run() error {
+	var encodeErr error
+	if c.format == "jsonl" {
+		encoder := json.NewEncoder(os.Stdout)
+		c.config.OnPeriod = func(config *rearview.Config, period rearview.PeriodResult) {
+			// like a closed pipe, nothing more can be written after the first error
+			if encodeErr == nil {
+				encodeErr = encoder.Encode(newPeriodLine(config, period))
 			}
 		}
+	}
+	if c.showProgress {
+		c.progress = &progressLine{writer: os.Stderr, start: time.Now(), checks: 1}
+		c.config.Progress = c.progress.report
+	}
 
-		if !satisfied {
-			logger.Tracef("not satisfied\n")
-			return failed
-		}
+	err := c.runMode()
+	if encodeErr != nil {
+		return encodeErr
 	}
+	return err
+}
 
-	return success
+// runMode checks the plan in the mode of flags
+func (c *checker) runMode() error {
+	switch {
+	case c.swr:
+		return c.safeWithdrawalRate()
+	case c.solveCost > 0:
+		return c.maxSafeCost()
+	case c.capitalRange != "":
+		return c.checkCapitalRange()
+	case c.compareStrategy != nil:
+		c.progress.setChecks(2)
+		return compare(c.config, []string{c.strategyName, c.compareName}, []rearview.Strategy{c.withdrawStrategy, c.compareStrategy}, c.format, c.style, c.check, c.logger)
+	case c.sweepCapitals != nil:
+		c.progress.setChecks(len(c.sweepCapitals) * len(c.sweepCosts))
+		return grid(c.resultWriter, c.config, c.sweepCapitals, c.sweepCosts, c.format, c.style, c.check, c.logger)
+	case c.sweepCosts != nil:
+		c.progress.setChecks(len(c.sweepCosts))
+		return sweep(c.config, c.sweepCosts, c.minRate, c.format, c.style, c.check, c.logger)
+	default:
+		return c.checkPlan()
+	}
 }
 
-// expected column order:
-// Date Open High
-// It's the format yahoo finace provided
-func parseCSVFile(file *os.File) ([]*datePrice, error) {
-	reader := csv.NewReader(file)
+// check checks config on the start days or monte carlo trials of flags
+func (c *checker) check(config *rearview.Config) (rearview.StrategyResult, error) {
+	// the line is cleared after each check, so results printed between checks stay intact
+	defer c.progress.next()
+	if c.monteCarlo > 0 {
+		return rearview.CheckMonteCarlo(c.ctx, config, c.datePrices, c.monteCarlo, c.seed, c.logger)
+	}
+	if len(c.starts) > 0 {
+		return rearview.CheckStarts(c.ctx, config, c.datePrices, c.starts, c.logger)
+	}
+	return rearview.CheckStrategy(c.ctx, config, c.datePrices, c.logger)
+}
 
-	// skip column name
-	_, err := reader.Read()
+// safeWithdrawalRate prints the highest cost per year of -swr as a rate of capital
+func (c *checker) safeWithdrawalRate() error {
+	c.progress.setChecks(0)
+	cost, err := rearview.MaxSafeCost(c.ctx, &c.config, c.datePrices, 1, c.logger)
+	c.progress.next()
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("can't find safe withdrawal rate: %w", err)
+	}
+	rate := float64(cost) / float64(c.config.Capital)
+	if c.quiet {
+		fmt.Printf("%f\n", rate)
+		return nil
 	}
+	c.logger.Printf("safe withdrawal rate %.2f%% of initial capital %d, cost per year %d\n", rate*100, c.config.Capital, cost)
+	return nil
+}
 
-	datePrices := []*datePrice{}
-	for {
-		line, err := reader.Read()
-		if err == io.EOF {
-			break
+// maxSafeCost prints the highest cost per year meeting the rate of -solve-cost
+func (c *checker) maxSafeCost() error {
+	// a binary search, how many checks it takes is not known
+	c.progress.setChecks(0)
+	cost, err := rearview.MaxSafeCost(c.ctx, &c.config, c.datePrices, c.solveCost, c.logger)
+	c.progress.next()
+	if err != nil {
+		return fmt.Errorf("can't solve cost per year: %w", err)
+	}
+	if c.quiet {
+		fmt.Printf("%d\n", cost)
+		return nil
+	}
+	c.logger.Printf("highest cost per year with successful rate of at least %f: %d\n", c.solveCost, cost)
+	return nil
+}
+
+// checkCapitalRange prints successful rate of trials of -capital-range by capital
+func (c *checker) checkCapitalRange() error {
+	result, err := rearview.CheckCapitalRange(c.ctx, &c.config, c.datePrices, c.capitalLow, c.capitalHigh, c.trials, c.seed, c.logger)
+	c.progress.next()
+	printCapitalBins(result.Periods, c.capitalLow, c.capitalHigh, c.config.NAAsFailed, c.style, c.logger)
+	if err != nil {
+		return fmt.Errorf("interrupted, partial results of %d checked trials", len(result.Periods))
+	}
+	return nil
+}
+
+// checkPlan checks the plan once, writes csv files of its periods and reports the result in -format
+func (c *checker) checkPlan() error {
+	result, err := c.check(&c.config)
+	interrupted := err != nil
+	if c.outPath != "" {
+		if err := writeResultCSV(c.outPath, result.Periods); err != nil {
+			return err
 		}
-		if err != nil {
-			return nil, err
+	}
+	if c.trajectoryPath != "" {
+		if err := writeTrajectoryCSV(c.trajectoryPath, result.Periods); err != nil {
+			return err
 		}
+	}
+	if c.ledgerPath != "" && len(result.Periods) > 0 {
+		if err := writeLedgerCSV(c.ledgerPath, result.Periods[0]); err != nil {
+			return err
+		}
+	}
 
-		year, month, day := 0, 0, 0
-		fmt.Sscanf(line[0], "%d-%d-%d", &year, &month, &day)
+	switch {
+	case c.quiet:
+		if result.Completed() > 0 {
+			fmt.Printf("%f\n", result.SuccessRate)
+		}
+	case c.format == "json":
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(encoded))
+	case c.format == "markdown":
+		printMarkdown(&c.config, c.strategyName, c.datePrices, result, c.logger)
+	default:
+		c.printText(result)
+	}
 
-		highPrice := float64(0)
-		fmt.Sscanf(line[2], "%f", &highPrice)
+	if interrupted {
+		return fmt.Errorf("interrupted, partial results of %d checked periods", result.Completed()+result.NACount)
+	}
+	// not enough data is not 0% success
+	if result.Completed() == 0 {
+		return errExit
+	}
+	if result.SuccessRate < c.minRate {
+		return fmt.Errorf("successful rate %f is below -min-rate %f", result.SuccessRate, c.minRate)
+	}
+	return nil
+}
 
-		datePrice := datePrice{
-			Date:      time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC),
-			HighPrice: highPrice,
+// printText prints result as text
+func (c *checker) printText(result rearview.StrategyResult) {
+	logger := c.logger
+	if len(c.starts) > 0 {
+		printStarts(c.starts, result.Periods, logger)
+	}
+	if result.Completed() == 0 {
+		logger.Printf("success %d, failed: %d, N/A: %d, no completed periods to evaluate\n", result.SuccessCount, result.FailedCount, result.NACount)
+		return
+	}
+	rateOf := ""
+	if c.config.NAAsFailed {
+		rateOf = " of success/(success+failed+N/A), N/A is counted as failed"
+	}
+	logger.Printf("success %d, failed: %d, N/A: %d, successful rate %f%s\n", result.SuccessCount, result.FailedCount, result.NACount, result.SuccessRate, rateOf)
+	logger.Printf("ending value of completed start days in dollars of start day, failed ones as 0: p5 %d, p25 %d\n",
+		int64(result.TailValue.P5), int64(result.TailValue.P25))
+	if result.SuccessCount > 0 {
+		value := result.EndingValue
+		logger.Printf("ending value of success in dollars of start day: min %d, p10 %d, median %d, p90 %d, max %d\n",
+			int64(value.Min), int64(value.P10), int64(value.Median), int64(value.P90), int64(value.Max))
+		logger.Printf("worst success starts on %s ending with %d, best success starts on %s ending with %d\n",
+			toyyyymmdd(result.WorstSuccess.Start), int64(result.WorstSuccess.EndingValue),
+			toyyyymmdd(result.BestSuccess.Start), int64(result.BestSuccess.EndingValue))
+	}
+	if result.FailedCount > 0 {
+		failedRuns := []string{}
+		for i, count := range result.FailedRuns {
+			failedRuns = append(failedRuns, fmt.Sprintf("run %d: %d", i+1, count))
 		}
-		datePrices = append(datePrices, &datePrice)
+		logger.Printf("failed in %s\n", strings.Join(failedRuns, ", "))
+	}
+	if rates := result.IRR; rates != nil {
+		logger.Printf("internal rate of return per year: min %.2f%%, p10 %.2f%%, median %.2f%%, p90 %.2f%%, max %.2f%%\n",
+			rates.Min*100, rates.P10*100, rates.Median*100, rates.P90*100, rates.Max*100)
+	} else if c.showIRR {
+		logger.Printf("no internal rate of return, no completed start day has one\n")
+	}
+	if years := result.YearsToRuin; years != nil && result.FailedCount > 0 {
+		logger.Printf("years survived before failing: min %.1f, p10 %.1f, median %.1f, p90 %.1f, max %.1f\n",
+			years.Min, years.P10, years.Median, years.P90, years.Max)
+	}
+	if c.perpetual {
+		logger.Printf("%d periods survived the entire data\n", result.SuccessCount)
+	}
+	if c.bucketYears > 0 {
+		printBuckets(result.Periods, c.bucketYears, c.config.NAAsFailed, c.style, logger)
+	}
+	if c.crash > 0 {
+		printSequenceRisk(c.datePrices, result.Periods, c.crash, c.crashYears, c.config.NAAsFailed, c.style, logger)
 	}
-
-	return datePrices, nil
 }
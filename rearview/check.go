@@ -0,0 +1,974 @@
+package rearview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is the outcome of a period
+type Status int
+
+const (
+	Success Status = iota
+	Failed
+	NA
+)
+
+func (s Status) String() string {
+	switch s {
+	case Success:
+		return "success"
+	case Failed:
+		return "failed"
+	case NA:
+		return "na"
+	default:
+		return fmt.Sprintf("unknown status %d", int(s))
+	}
+}
+
+// StrategyResult is the outcome of checking a strategy over every start day
+type StrategyResult struct {
+	SuccessCount int `json:"successCount"`
+	FailedCount  int `json:"failedCount"`
+	NACount      int `json:"naCount"`
+	// SuccessRate is success of completed periods, or of all periods with Config.NAAsFailed
+	SuccessRate float64 `json:"successRate"`
+	// ending value of successful periods in dollars of their first day
+	EndingValue Percentiles `json:"endingValue"`
+	// TailValue is ending value of completed periods in dollars of their first day, failed ones end with 0,
+	// so it's the worst cases the successful rate doesn't tell
+	TailValue Tail `json:"tailValue"`
+	// FailedRuns[i] is how many failed periods fall short in run i+1
+	FailedRuns []int `json:"failedRuns"`
+	// WorstSuccess and BestSuccess are successful periods of the least and the most ending value, nil if none succeeds
+	WorstSuccess *StartValue `json:"worstSuccess,omitempty"`
+	BestSuccess  *StartValue `json:"bestSuccess,omitempty"`
+	// YearsToRuin is how many years failed periods last with Config.Perpetual, nil without it
+	YearsToRuin *Percentiles `json:"yearsToRuin,omitempty"`
+	// IRR is PeriodResult.IRR of completed periods which have one with Config.IRR, nil without it or if none has one
+	IRR *Percentiles `json:"irr,omitempty"`
+
+	// Periods are results of every start day or trial in order, nil with Config.OnPeriod which streams them instead
+	Periods []PeriodResult `json:"-"`
+
+	endingValues []float64
+	// completedValues are ending values of completed periods, 0 for failed ones
+	completedValues []float64
+}
+
+// StartValue is the start day and ending value of a period
+type StartValue struct {
+	Start       time.Time `json:"start"`
+	EndingValue float64   `json:"endingValue"`
+}
+
+// Percentiles of a distribution
+type Percentiles struct {
+	Min    float64 `json:"min"`
+	P10    float64 `json:"p10"`
+	Median float64 `json:"median"`
+	P90    float64 `json:"p90"`
+	Max    float64 `json:"max"`
+}
+
+// Tail is low percentiles of a distribution
+type Tail struct {
+	P5  float64 `json:"p5"`
+	P25 float64 `json:"p25"`
+}
+
+// newPercentiles computes percentiles of values by nearest rank, values are sorted in place
+func newPercentiles(values []float64) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+	sort.Float64s(values)
+	return Percentiles{
+		Min:    values[0],
+		P10:    nearestRank(values, 0.1),
+		Median: nearestRank(values, 0.5),
+		P90:    nearestRank(values, 0.9),
+		Max:    values[len(values)-1],
+	}
+}
+
+// newTail is newPercentiles of the tail
+func newTail(values []float64) Tail {
+	if len(values) == 0 {
+		return Tail{}
+	}
+	sort.Float64s(values)
+	return Tail{
+		P5:  nearestRank(values, 0.05),
+		P25: nearestRank(values, 0.25),
+	}
+}
+
+// nearestRank is percentile p of sorted values
+func nearestRank(sorted []float64, p float64) float64 {
+	return sorted[int(math.Round(p*float64(len(sorted)-1)))]
+}
+
+// add counts the result of checkInPeriod
+func (r *StrategyResult) add(period PeriodResult) {
+	switch period.Status {
+	case Success:
+		r.SuccessCount++
+		r.endingValues = append(r.endingValues, period.EndingValue)
+		r.completedValues = append(r.completedValues, period.EndingValue)
+		if r.WorstSuccess == nil || period.EndingValue < r.WorstSuccess.EndingValue {
+			r.WorstSuccess = &StartValue{period.Start, period.EndingValue}
+		}
+		if r.BestSuccess == nil || period.EndingValue > r.BestSuccess.EndingValue {
+			r.BestSuccess = &StartValue{period.Start, period.EndingValue}
+		}
+	case Failed:
+		r.FailedCount++
+		r.completedValues = append(r.completedValues, 0)
+		for len(r.FailedRuns) < period.Runs {
+			r.FailedRuns = append(r.FailedRuns, 0)
+		}
+		r.FailedRuns[period.Runs-1]++
+	case NA:
+		r.NACount++
+	default:
+		panic(fmt.Sprintf("unknow check result %d", period.Status))
+	}
+}
+
+// Completed is the count of start days which are either success or failed
+func (r *StrategyResult) Completed() int {
+	return r.SuccessCount + r.FailedCount
+}
+
+// CheckStrategy checks every start day, start days are split across config.workers() goroutines.
+// If ctx is cancelled, it stops early with results of start days checked so far and ctx.Err().
+func CheckStrategy(ctx context.Context, config *Config, datePrices []DatePrice, logger Logger) (StrategyResult, error) {
+	if err := config.validateRuns(); err != nil {
+		return StrategyResult{}, err
+	}
+	config = config.perpetualRuns(datePrices)
+	periods := make([]PeriodResult, len(datePrices))
+	ends := findRunEnds(config, datePrices)
+	checked := parallel(ctx, len(datePrices), config.workers(), config.finished(periods), func(i int) {
+		periods[i] = checkInPeriod(config, datePrices[i:], ends[i*config.Run:(i+1)*config.Run], logger)
+	})
+	return config.result(periods, checked), ctx.Err()
+}
+
+// CheckStarts is CheckStrategy of the closest days of starts only, periods are in the order of starts,
+// a start after the last day is N/A
+func CheckStarts(ctx context.Context, config *Config, datePrices []DatePrice, starts []time.Time, logger Logger) (StrategyResult, error) {
+	if err := config.validateRuns(); err != nil {
+		return StrategyResult{}, err
+	}
+	config = config.perpetualRuns(datePrices)
+	periods := make([]PeriodResult, len(starts))
+	checked := parallel(ctx, len(starts), config.workers(), config.finished(periods), func(i int) {
+		index, found := findClosestDay(starts[i], datePrices)
+		if !found {
+			periods[i] = PeriodResult{Start: starts[i], Capital: config.Capital, Status: NA}
+			return
+		}
+		periods[i] = checkInPeriod(config, datePrices[index:], nil, logger)
+	})
+	return config.result(periods, checked), ctx.Err()
+}
+
+// perpetualRuns is config with one more run than datePrices have with Config.Perpetual,
+// so every period runs until data ends or it fails
+func (c *Config) perpetualRuns(datePrices []DatePrice) *Config {
+	if !c.Perpetual || len(datePrices) == 0 {
+		return c
+	}
+	perpetual := *c
+	perpetual.Run = int(yearsBetween(datePrices[0].Date, datePrices[len(datePrices)-1].Date))/c.YearPerRun + 1
+	return &perpetual
+}
+
+// result summarizes periods which are checked, with YearsToRuin of Config.Perpetual,
+// periods are kept in it unless Config.OnPeriod streams them
+func (c *Config) result(periods []PeriodResult, checked []bool) StrategyResult {
+	result := summarize(periods, checked, c.NAAsFailed, c.OnPeriod == nil)
+	if c.Perpetual {
+		years := []float64{}
+		for i, period := range periods {
+			if checked[i] && period.Status == Failed {
+				years = append(years, period.Years)
+			}
+		}
+		yearsToRuin := newPercentiles(years)
+		result.YearsToRuin = &yearsToRuin
+	}
+	if c.IRR {
+		rates := []float64{}
+		for i, period := range periods {
+			if checked[i] && period.Status != NA && !math.IsNaN(period.IRR) {
+				rates = append(rates, period.IRR)
+			}
+		}
+		if len(rates) > 0 {
+			irr := newPercentiles(rates)
+			result.IRR = &irr
+		}
+	}
+	return result
+}
+
+// findRunEnds finds end days of runs of every start day at once,
+// ends[i*config.Run+run] is the index of the end day of run of the period starting from day i, relative to i,
+// it's -1 if data ends before it.
+// End days move forward with start days, so one sweep per run replaces a binary search per run of every period.
+// It picks the same day as findClosestDay, the earliest of duplicate dates too.
+func findRunEnds(config *Config, datePrices []DatePrice) []int {
+	n := len(datePrices)
+	if config.Run <= 0 {
+		return nil
+	}
+	ends := make([]int, n*config.Run)
+	days := make([]time.Time, n)
+	for run := 0; run < config.Run; run++ {
+		// next is the first index whose date is not before the end day, as sort.Search in findClosestDate
+		next := 0
+		for i := range days {
+			days[i] = config.runEnd(datePrices[i].Date, run)
+			if i > 0 && days[i].Before(days[i-1]) {
+				next = 0
+			}
+			for next < n && datePrices[next].Date.Before(days[i]) {
+				next++
+			}
+			end := -1
+			if next < n {
+				end = next
+				if next > 0 && days[i].Sub(datePrices[next-1].Date) <= datePrices[next].Date.Sub(days[i]) {
+					end = next - 1
+					for end > 0 && datePrices[end-1].Date.Equal(datePrices[end].Date) {
+						end--
+					}
+				}
+				end -= i
+			}
+			ends[i*config.Run+run] = end
+		}
+	}
+	return ends
+}
+
+// CheckMonteCarlo checks trials of synthetic price paths resampled from datePrices,
+// trial i always uses the same path for the same seed, datePrices needs at least 2 days.
+// If ctx is cancelled, it stops early with results of trials checked so far and ctx.Err().
+func CheckMonteCarlo(ctx context.Context, config *Config, datePrices []DatePrice, trials int, seed int64, logger Logger) (StrategyResult, error) {
+	if err := config.validateRuns(); err != nil {
+		return StrategyResult{}, err
+	}
+	if len(datePrices) < 2 {
+		return summarize(nil, nil, config.NAAsFailed, true), errors.New("monte carlo needs at least 2 days of data for daily returns")
+	}
+	// seeds of trials are drawn up front so paths don't depend on scheduling of workers
+	seeds := make([]int64, trials)
+	random := rand.New(rand.NewSource(seed))
+	for i := range seeds {
+		seeds[i] = random.Int63()
+	}
+
+	periods := make([]PeriodResult, trials)
+	// runs begin after years of saving
+	years := config.AccumulateYears + config.Run*config.YearPerRun
+	if config.AnniversaryMonth != 0 {
+		// room for the longer first run
+		years++
+	}
+	checked := parallel(ctx, trials, config.workers(), config.finished(periods), func(i int) {
+		random := rand.New(rand.NewSource(seeds[i]))
+		periods[i] = checkInPeriod(config, resample(datePrices, years, random), nil, logger)
+	})
+	return config.result(periods, checked), ctx.Err()
+}
+
+// CheckCapitalRange checks trials of a capital drawn in [low, high] from a random start day of datePrices
+// with data for every run, Capital of result.Periods tells what each trial draws.
+// If ctx is cancelled, it stops early with results of trials checked so far and ctx.Err().
+func CheckCapitalRange(ctx context.Context, config *Config, datePrices []DatePrice, low, high int64, trials int, seed int64, logger Logger) (StrategyResult, error) {
+	if err := config.validateRuns(); err != nil {
+		return StrategyResult{}, err
+	}
+	if low > high || trials < 0 {
+		return StrategyResult{}, fmt.Errorf("invalid capital range %d to %d or trials %d", low, high, trials)
+	}
+	if config.Perpetual {
+		// no start day has data for runs until data ends
+		return StrategyResult{}, errors.New("capital range checks start days with data for every run, it can't be perpetual")
+	}
+	ends := findRunEnds(config, datePrices)
+	starts := []int{}
+	for i := range datePrices {
+		if ends[i*config.Run+config.Run-1] >= 0 {
+			starts = append(starts, i)
+		}
+	}
+	if len(starts) == 0 {
+		return summarize(nil, nil, config.NAAsFailed, true), ctx.Err()
+	}
+
+	// draws are made up front so trials don't depend on scheduling of workers
+	capitals, trialStarts := make([]int64, trials), make([]int, trials)
+	random := rand.New(rand.NewSource(seed))
+	for i := range capitals {
+		capitals[i] = low + random.Int63n(high-low+1)
+		trialStarts[i] = starts[random.Intn(len(starts))]
+	}
+
+	periods := make([]PeriodResult, trials)
+	checked := parallel(ctx, trials, config.workers(), config.finished(periods), func(i int) {
+		trialConfig := *config
+		trialConfig.Capital = capitals[i]
+		start := trialStarts[i]
+		periods[i] = checkInPeriod(&trialConfig, datePrices[start:], ends[start*config.Run:(start+1)*config.Run], logger)
+	})
+	return config.result(periods, checked), ctx.Err()
+}
+
+// parallel calls f(i) for i in [0, n) across workers goroutines until ctx is cancelled,
+// checked[i] tells if f(i) is called.
+// finished is called with i and how many are done after each f if it's not nil, calls are never concurrent.
+func parallel(ctx context.Context, n, workers int, finished func(i, done int), f func(i int)) []bool {
+	checked := make([]bool, n)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n && ctx.Err() == nil; i += workers {
+				f(i)
+				checked[i] = true
+				if finished != nil {
+					mu.Lock()
+					done++
+					finished(i, done)
+					mu.Unlock()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return checked
+}
+
+// finished reports periods[i] is checked and done of periods are checked to OnPeriod and Progress
+func (c *Config) finished(periods []PeriodResult) func(i, done int) {
+	if c.OnPeriod == nil && c.Progress == nil {
+		return nil
+	}
+	return func(i, done int) {
+		if c.OnPeriod != nil {
+			c.OnPeriod(c, periods[i])
+			// only the counts of a streamed period are summarized, its trajectory and sales go with it
+			periods[i].Trajectory, periods[i].Sales = nil, nil
+		}
+		if c.Progress != nil {
+			c.Progress(done, len(periods))
+		}
+	}
+}
+
+// Summarize counts periods, like a subset of Periods of a StrategyResult, see Config.NAAsFailed of naAsFailed
+func Summarize(periods []PeriodResult, naAsFailed bool) StrategyResult {
+	checked := make([]bool, len(periods))
+	for i := range checked {
+		checked[i] = true
+	}
+	return summarize(periods, checked, naAsFailed, true)
+}
+
+// summarize counts periods which are checked, they're in Periods of the result if keep
+func summarize(periods []PeriodResult, checked []bool, naAsFailed, keep bool) StrategyResult {
+	result := StrategyResult{}
+	if keep {
+		result.Periods = make([]PeriodResult, 0, len(periods))
+	}
+	for i, period := range periods {
+		if !checked[i] {
+			continue
+		}
+		if keep {
+			result.Periods = append(result.Periods, period)
+		}
+		result.add(period)
+	}
+	periodCount := result.Completed()
+	if naAsFailed {
+		periodCount += result.NACount
+	}
+	if periodCount > 0 {
+		result.SuccessRate = float64(result.SuccessCount) / float64(periodCount)
+	}
+	result.EndingValue = newPercentiles(result.endingValues)
+	result.TailValue = newTail(result.completedValues)
+	return result
+}
+
+// resample builds a synthetic price path longer than years starting from datePrices[0],
+// each day takes the daily return and the gap of dates of a random historical day
+func resample(datePrices []DatePrice, years int, random *rand.Rand) []DatePrice {
+	first := datePrices[0]
+	endDay := first.Date.AddDate(years, 0, 0)
+	path := []DatePrice{first}
+	for prev := first; !prev.Date.After(endDay); {
+		j := 1 + random.Intn(len(datePrices)-1)
+		day, prevDay := datePrices[j], datePrices[j-1]
+		// prices of the day relative to close of the previous day
+		ratio := prev.ClosePrice / prevDay.ClosePrice
+		next := DatePrice{
+			Date:       prev.Date.Add(day.Date.Sub(prevDay.Date)),
+			OpenPrice:  day.OpenPrice * ratio,
+			HighPrice:  day.HighPrice * ratio,
+			LowPrice:   day.LowPrice * ratio,
+			ClosePrice: day.ClosePrice * ratio,
+			AdjClose:   prev.AdjClose * day.AdjClose / prevDay.AdjClose,
+		}
+		path = append(path, next)
+		prev = next
+	}
+	return path
+}
+
+// PeriodResult is the outcome of checking a strategy from one start day
+type PeriodResult struct {
+	Start time.Time
+	// Capital is the initial capital of the period
+	Capital int64
+	Status  Status
+	// Runs is how many runs the period reaches, the last one is the failed or N/A run if it's not success
+	Runs int
+	// Years is how many years the period lasts until it fails, or until it ends
+	Years float64
+	// EndingValue is the value of the portfolio when the period ends, in dollars of its first day
+	EndingValue float64
+	// FailedDate is the day a failed period falls short, and FailedReason is why
+	FailedDate   time.Time
+	FailedReason string
+	// Trajectory is the portfolio on the first day and at the end of every run done if Config.Trajectory is set
+	Trajectory []RunPoint
+	// Sales are shares sold to fund cost of living in the order they're sold if Config.Ledger is set
+	Sales []Sale
+	// IRR is the annual money-weighted return of a completed period if Config.IRR is set,
+	// of the initial capital and contributions invested, withdrawals received and the portfolio left at the end.
+	// It's NaN if there's no such return, 0 for N/A periods.
+	IRR float64
+}
+
+// Sale is a sale of shares on a day
+type Sale struct {
+	Date time.Time
+	// Asset is shares of stocks or bond shares
+	Asset  string
+	Shares float64
+	Price  float64
+	// Proceeds is shares times price, before tax and fee
+	Proceeds float64
+	Tax      float64
+	Fee      float64
+	// Remained is shares of the asset held after the sale
+	Remained float64
+}
+
+// RunPoint is the portfolio on a run boundary
+type RunPoint struct {
+	// Run is how many runs are done, 0 is the first day
+	Run  int
+	Date time.Time
+	// Value is the portfolio in dollars of the day and RealValue is it in dollars of the first day
+	Value      float64
+	RealValue  float64
+	Shares     float64
+	BondShares float64
+	Cash       float64
+}
+
+// fail ends the period as failed on date, value is the portfolio in dollars of the first day
+func (r PeriodResult) fail(date time.Time, reason string, value float64) PeriodResult {
+	r.Status = Failed
+	r.Years = yearsBetween(r.Start, date)
+	r.FailedDate = date
+	r.FailedReason = reason
+	r.EndingValue = value
+	return r
+}
+
+// checkInPeriod checks the period starting from datePrices[0], it's N/A without datePrices,
+// ends are end days of its runs from findRunEnds, or nil to search them
+func checkInPeriod(config *Config, datePrices []DatePrice, ends []int, logger Logger) PeriodResult {
+	if len(datePrices) == 0 {
+		// nothing to start from
+		return PeriodResult{Status: NA}
+	}
+	// initial shares, their average cost is the basis of capital gains
+	portfolio := config.newPortfolio(&datePrices[0])
+	switch {
+	case config.Bonds != nil:
+		logger.Tracef("initial: capital %d, it can buy %.4f shares and %.4f bond shares, cash %d\n\n", config.Capital, portfolio.stocks.shares, portfolio.bonds.shares, int64(portfolio.cash))
+	case portfolio.cash > 0:
+		logger.Tracef("initial: capital %d, it can buy %.4f shares, cash %d\n\n", config.Capital, portfolio.stocks.shares, int64(portfolio.cash))
+	default:
+		logger.Tracef("initial: capital %d, it can buy %.4f shares\n\n", config.Capital, portfolio.stocks.shares)
+	}
+
+	// inflationOn is inflation from the first day to date, 1 if it's unknown
+	inflationOn := func(date time.Time) float64 {
+		first := datePrices[0].Date
+		inflationRate, ok := config.inflation(first, date, yearsBetween(first, date))
+		if !ok {
+			return 1
+		}
+		return inflationRate
+	}
+	// realValue is the portfolio on the day in dollars of the first day
+	realValue := func(datePrice *DatePrice) float64 {
+		return config.capital(portfolio, datePrice) / inflationOn(datePrice.Date)
+	}
+
+	result := PeriodResult{
+		Start:       datePrices[0].Date,
+		Capital:     config.Capital,
+		Status:      NA,
+		EndingValue: float64(config.Capital),
+	}
+	// point records the portfolio on the day after run runs for Config.Trajectory
+	point := func(run int, datePrice *DatePrice) {
+		if !config.Trajectory {
+			return
+		}
+		result.Trajectory = append(result.Trajectory, RunPoint{
+			Run:        run,
+			Date:       datePrice.Date,
+			Value:      config.capital(portfolio, datePrice),
+			RealValue:  realValue(datePrice),
+			Shares:     portfolio.stocks.shares,
+			BondShares: portfolio.bonds.shares,
+			Cash:       config.cashValue(portfolio, datePrice.Date),
+		})
+	}
+	point(0, &datePrices[0])
+	// flows are cash flows after the initial capital for Config.IRR
+	flows := []cashFlow{}
+	flow := func(date time.Time, amount float64) {
+		if config.IRR {
+			flows = append(flows, cashFlow{date, amount})
+		}
+	}
+	// withIRR is period with IRR of flows and the portfolio on the day as if it's sold
+	withIRR := func(period PeriodResult, datePrice *DatePrice) PeriodResult {
+		if config.IRR {
+			all := append([]cashFlow{{datePrices[0].Date, -float64(config.Capital)}}, flows...)
+			period.IRR = irr(append(all, cashFlow{datePrice.Date, config.capital(portfolio, datePrice)}))
+		}
+		return period
+	}
+	// record keeps sales of the day for Config.Ledger
+	record := func(date time.Time, sales []sale) {
+		if !config.Ledger {
+			return
+		}
+		for _, sale := range sales {
+			result.Sales = append(result.Sales, Sale{
+				Date:     date,
+				Asset:    sale.name,
+				Shares:   sale.shares,
+				Price:    sale.price,
+				Proceeds: sale.shares * sale.price,
+				Tax:      sale.tax,
+				Fee:      sale.fee,
+				Remained: sale.remained,
+			})
+		}
+	}
+	endDay, endIndex := datePrices[0].Date, 0
+	// the last day a run ends on
+	lastDay := &datePrices[0]
+	// what strategies know about the previous run
+	withdrawn, prevCapital, prevInflationRate := 0.0, 0.0, 1.0
+	principal := float64(config.Capital)
+	if config.AccumulateYears > 0 {
+		index, ok := config.accumulate(portfolio, datePrices, flow, logger)
+		if !ok {
+			return result
+		}
+		endDay, endIndex = anniversary(datePrices[0].Date, config.AccumulateYears), index
+		principal, prevInflationRate = realValue(&datePrices[index]), inflationOn(endDay)
+	}
+	// dataEnds ends the period when there is no data for the run, it's N/A,
+	// or success with Config.Perpetual if any run is done since it survives the whole data
+	dataEnds := func(run int) PeriodResult {
+		if config.Perpetual && run > 0 {
+			result.Status, result.Runs = Success, run
+			return withIRR(result, lastDay)
+		}
+		return result
+	}
+	for run := 0; run < config.Run; run++ {
+		result.Runs = run + 1
+		// a run starts from the end day of the previous run
+		startIndex, startDay := endIndex, endDay
+		endDay = config.runEnd(datePrices[0].Date, run)
+		found := false
+		if ends != nil {
+			endIndex, found = ends[run], ends[run] >= 0
+		} else {
+			endIndex, found = findClosestDay(endDay, datePrices)
+		}
+		fraction := 1.0
+		if !found && config.Partial && len(datePrices)-1 > startIndex {
+			// the last run ends on the last day, so it's shorter than a whole run
+			endIndex, found = len(datePrices)-1, true
+			fraction = yearsBetween(startDay, datePrices[endIndex].Date) / float64(config.YearPerRun)
+			endDay = datePrices[endIndex].Date
+			logger.Tracef("data ends on %s, %.2f of the run is checked\n", toyyyymmdd(endDay), fraction)
+		}
+		if !found {
+			logger.Tracef("no more available date to test\n")
+			return dataEnds(run)
+		}
+		if gap := daysBetween(endDay, datePrices[endIndex].Date); config.MaxGap > 0 && gap > config.MaxGap {
+			logger.Tracef("closest day of %s is %s, %d days away is more than max gap %d\n", toyyyymmdd(endDay), toyyyymmdd(datePrices[endIndex].Date), gap, config.MaxGap)
+			return dataEnds(run)
+		}
+		if _, found := findClosestDay(endDay, config.Bonds); config.Bonds != nil && !found {
+			logger.Tracef("no bond price available for %s\n", toyyyymmdd(endDay))
+			return dataEnds(run)
+		}
+
+		inflationYears := float64(config.AccumulateYears) + (float64(run)+fraction)*float64(config.YearPerRun)
+		if config.AnniversaryMonth != 0 {
+			// the first run is longer
+			inflationYears = yearsBetween(datePrices[0].Date, endDay)
+		}
+		inflationRate, ok := config.inflation(datePrices[0].Date, endDay, inflationYears)
+		if !ok {
+			logger.Tracef("no cpi available from %s to %s\n", toyyyymmdd(datePrices[0].Date), toyyyymmdd(endDay))
+			return dataEnds(run)
+		}
+
+		config.reinvestDividends(portfolio, &datePrices[startIndex])
+		config.rebalance(portfolio, &datePrices[startIndex])
+		if config.Bonds != nil {
+			logger.Debugf("%s rebalanced to %.4f shares and %.4f bond shares\n", toyyyymmdd(datePrices[startIndex].Date), portfolio.stocks.shares, portfolio.bonds.shares)
+		}
+		startValue := config.capital(portfolio, &datePrices[startIndex])
+		state := RunState{
+			Run:               run,
+			StartIndex:        startIndex,
+			EndIndex:          endIndex,
+			InflationRate:     inflationRate,
+			Fraction:          fraction,
+			HeldShares:        portfolio.stocks.shares,
+			BondShares:        portfolio.bonds.shares,
+			Withdrawn:         withdrawn,
+			PrevCapital:       prevCapital,
+			PrevInflationRate: prevInflationRate,
+			Principal:         principal,
+			config:            config,
+			datePrices:        datePrices,
+			portfolio:         portfolio,
+		}
+		currIndex, costOfLiving, err := config.strategy().Withdraw(config, datePrices, &state, logger)
+		if err != nil {
+			logger.Tracef("not satisfied, %v\n", err)
+			failedDay := &datePrices[currIndex]
+			return withIRR(result.fail(failedDay.Date, err.Error(), realValue(failedDay)), failedDay)
+		}
+		withdrawn, prevInflationRate = costOfLiving, inflationRate
+		datePrice := &datePrices[currIndex]
+		if years := yearsBetween(datePrices[startIndex].Date, datePrice.Date); years > 0 {
+			growth := math.Pow(config.capital(portfolio, datePrice)/startValue, 1/years) - 1
+			logger.Tracef("%s satisfied, portfolio grew %.2f%% per year in %.1f years\n", toyyyymmdd(datePrice.Date), growth*100, years)
+		}
+
+		// sold shares to get money ^^
+		payments := []payment{{currIndex, costOfLiving}}
+		if config.Monthly {
+			payments = config.monthlyPayments(datePrices, startDay, costOfLiving, inflationRate, fraction)
+		} else if config.Pessimistic {
+			payments[0].index = lowestIndex(datePrices, startIndex, endIndex)
+		}
+		for _, payment := range payments {
+			datePrice = &datePrices[payment.index]
+			if payment.amount <= 0 {
+				// income covers the cost of living, nothing to sell
+				continue
+			}
+			if config.Pessimistic {
+				datePrice = atLowPrice(datePrice)
+			}
+			config.reinvestDividends(portfolio, datePrice)
+			sales, ok := config.pay(portfolio, datePrice, payment.amount, inflationRate, logger)
+			if !ok {
+				return withIRR(result.fail(datePrice.Date, "cost of living is not funded", realValue(datePrice)), datePrice)
+			}
+			flow(datePrice.Date, payment.amount)
+			record(datePrice.Date, sales)
+		}
+		if config.ReserveYears > 0 {
+			reserve := config.reserve(run, inflationRate)
+			record(datePrice.Date, config.refillReserve(portfolio, datePrice, principal*inflationRate, reserve, inflationRate, logger))
+		}
+		prevCapital = config.capital(portfolio, datePrice)
+		logger.Tracef("new capital %d\n\n", int(config.traced(prevCapital, inflationRate)))
+		config.reinvestDividends(portfolio, &datePrices[endIndex])
+		result.EndingValue = config.capital(portfolio, &datePrices[endIndex]) / inflationRate
+		result.Years = float64(config.AccumulateYears) + (float64(run)+fraction)*float64(config.YearPerRun)
+		point(run+1, &datePrices[endIndex])
+		lastDay = &datePrices[endIndex]
+		if fraction < 1 {
+			// data ends with this run
+			break
+		}
+	}
+
+	result.Status = Success
+	return withIRR(result, lastDay)
+}
+
+// accumulate invests Config.Contribution at the end of each of Config.AccumulateYears of the period starting from datePrices[0],
+// it returns the index of the last day of accumulation, or false if data ends before it.
+// Each contribution is a cash flow invested.
+func (c *Config) accumulate(portfolio *portfolio, datePrices []DatePrice, flow func(date time.Time, amount float64), logger Logger) (int, bool) {
+	first := datePrices[0].Date
+	index := 0
+	for year := 1; year <= c.AccumulateYears; year++ {
+		day := anniversary(first, year)
+		found := false
+		if index, found = findClosestDay(day, datePrices); !found {
+			logger.Tracef("no more available date to accumulate\n")
+			return 0, false
+		}
+		inflationRate, ok := c.inflation(first, day, float64(year))
+		if !ok {
+			logger.Tracef("no cpi available for %s\n", toyyyymmdd(day))
+			return 0, false
+		}
+		datePrice := &datePrices[index]
+		c.reinvestDividends(portfolio, datePrice)
+		c.contribute(portfolio, datePrice, float64(c.Contribution)*inflationRate)
+		flow(datePrice.Date, -float64(c.Contribution)*inflationRate)
+		logger.Tracef("%s contribute %d, capital %d, shares %.4f\n",
+			toyyyymmdd(datePrice.Date),
+			int64(c.traced(float64(c.Contribution)*inflationRate, inflationRate)),
+			int64(c.traced(c.capital(portfolio, datePrice), inflationRate)),
+			portfolio.stocks.shares,
+		)
+	}
+	logger.Tracef("\n")
+	return index, true
+}
+
+// lowestIndex is the index of the day of the lowest low price in [start, end)
+func lowestIndex(datePrices []DatePrice, start, end int) int {
+	lowest := start
+	for i := start; i < end; i++ {
+		if datePrices[i].LowPrice < datePrices[lowest].LowPrice {
+			lowest = i
+		}
+	}
+	return lowest
+}
+
+// atLowPrice is the day with every price being its low price, so anything sold on it is sold at the low
+func atLowPrice(datePrice *DatePrice) *DatePrice {
+	low := *datePrice
+	low.OpenPrice, low.HighPrice, low.ClosePrice, low.AdjClose = low.LowPrice, low.LowPrice, low.LowPrice, low.LowPrice
+	return &low
+}
+
+// payment is an amount of cost of living withdrawn on the day of index
+type payment struct {
+	index  int
+	amount float64
+}
+
+// monthlyPayments spreads costOfLiving of a run starting from startDay across its months,
+// each month pays its share adjusted by inflation until that month instead of the end of the run,
+// fraction of a partial run has fewer months
+func (c *Config) monthlyPayments(datePrices []DatePrice, startDay time.Time, costOfLiving, inflationRate, fraction float64) []payment {
+	months := int(math.Max(math.Round(12*float64(c.YearPerRun)*fraction), 1))
+	payments := make([]payment, 0, months)
+	for month := 0; month < months; month++ {
+		day := startDay.AddDate(0, month, 0)
+		index, _ := findClosestDay(day, datePrices)
+		monthInflation, ok := c.inflation(datePrices[0].Date, day, yearsBetween(datePrices[0].Date, day))
+		if !ok {
+			monthInflation = inflationRate
+		}
+		payments = append(payments, payment{index, costOfLiving / float64(months) * monthInflation / inflationRate})
+	}
+	return payments
+}
+
+// pay withdraws amount from portfolio on the day and traces it, it returns sales of the withdrawal,
+// or false if amount can't be funded.
+// inflationRate is inflation from the first day of the period to the day for Config.RealTrace
+func (c *Config) pay(portfolio *portfolio, datePrice *DatePrice, amount, inflationRate float64, logger Logger) ([]sale, bool) {
+	withdrawal, shortfall, ok := c.withdraw(portfolio, datePrice, amount)
+	if !ok {
+		// can't sell more than we hold, cost of living is not funded
+		logger.Tracef("%s only %.4f shares held, short of cost of living by %d, fee %d is included\n",
+			toyyyymmdd(datePrice.Date),
+			portfolio.stocks.shares,
+			int64(c.traced(shortfall, inflationRate)),
+			int64(c.traced(c.Fee.Of(c.capital(portfolio, datePrice)), inflationRate)),
+		)
+		return nil, false
+	}
+	if withdrawal.cash > 0 {
+		logger.Tracef("%s withdraw %d from cash, remained cash %d\n",
+			toyyyymmdd(datePrice.Date),
+			int64(c.traced(withdrawal.cash, inflationRate)),
+			int64(c.traced(portfolio.cash, inflationRate)),
+		)
+	}
+	c.traceSales(datePrice, withdrawal.sales, inflationRate, logger)
+	return withdrawal.sales, true
+}
+
+// traceSales traces sales on the day, inflationRate is inflation from the first day of the period to the day for Config.RealTrace
+func (c *Config) traceSales(datePrice *DatePrice, sales []sale, inflationRate float64, logger Logger) {
+	for _, sale := range sales {
+		logger.Tracef("%s sell %.4f %s in %f, earn %d, pay tax %d, fee %d, remained shares %.4f\n",
+			toyyyymmdd(datePrice.Date),
+			sale.shares,
+			sale.name,
+			c.traced(sale.price, inflationRate),
+			int64(c.traced(sale.shares*sale.price, inflationRate)),
+			int64(c.traced(sale.tax, inflationRate)),
+			int64(c.traced(sale.fee, inflationRate)),
+			sale.remained,
+		)
+		logger.Debugf("%s cost basis %f, gain %d\n", sale.name, sale.costBasis, int64(sale.shares*(sale.price-sale.costBasis)))
+	}
+}
+
+// refillReserve sells shares on the day to top cash up to reserve, only as much as capital is above target,
+// so shares aren't sold for the reserve when they're down. It traces and returns sales of the top up.
+func (c *Config) refillReserve(p *portfolio, datePrice *DatePrice, target, reserve, inflationRate float64, logger Logger) []sale {
+	cash := c.cashValue(p, datePrice.Date)
+	amount := math.Min(reserve-cash, c.capital(p, datePrice)-target)
+	if amount <= 0 {
+		return nil
+	}
+	before := *p
+	sales, _, ok := c.sell(p, datePrice, amount)
+	if !ok {
+		*p = before
+		return nil
+	}
+	p.cash, p.cashDate = cash+amount, datePrice.Date
+	logger.Tracef("%s top up cash reserve by %d to %d\n",
+		toyyyymmdd(datePrice.Date),
+		int64(c.traced(amount, inflationRate)),
+		int64(c.traced(p.cash, inflationRate)),
+	)
+	c.traceSales(datePrice, sales, inflationRate, logger)
+	return sales
+}
+
+// MaxSafeCost finds the highest cost per year, to a dollar, which keeps the successful rate of CheckStrategy
+// at least targetRate. The rate mostly falls as cost rises, so it's a binary search, see maxCost for how it wobbles,
+// the returned cost is always one which is checked to meet targetRate.
+func MaxSafeCost(ctx context.Context, config *Config, datePrices []DatePrice, targetRate float64, logger Logger) (int, error) {
+	trial := *config
+	meets := func(cost int) (bool, error) {
+		trial.CostPerYear = cost
+		result, err := CheckStrategy(ctx, &trial, datePrices, logger)
+		if err != nil {
+			return false, err
+		}
+		if result.Completed() == 0 {
+			return false, errors.New("no completed periods to evaluate")
+		}
+		return result.SuccessRate >= targetRate, nil
+	}
+
+	ok, err := meets(0)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("successful rate is below %f even without cost of living", targetRate)
+	}
+	return maxCost(config.CostPerYear, meets)
+}
+
+// wobbleTolerance is how far above the cost a binary search ends with maxCost looks for a higher one which meets target,
+// and wobbleProbes is how many costs it checks in between
+const (
+	wobbleTolerance = 0.05
+	wobbleProbes    = 5
+)
+
+// maxCost is the highest cost found to meet target from 0 which meets it, and first a guess of the highest one.
+// A period which just fails at a cost may succeed at a slightly higher one, e.g. a sale which crosses a run boundary,
+// so a binary search may end below a hole of costs which don't meet target. It checks costs up to wobbleTolerance above
+// where it ends, and searches again above the highest one which meets target.
+func maxCost(guess int, meets func(cost int) (bool, error)) (int, error) {
+	// low meets target and high doesn't
+	low, high := 0, guess
+	for {
+		if high <= low {
+			high = low + 1
+		}
+		for {
+			ok, err := meets(high)
+			if err != nil {
+				return 0, err
+			}
+			if !ok {
+				break
+			}
+			if high > math.MaxInt32 {
+				return 0, errors.New("any cost of living meets target rate")
+			}
+			low, high = high, high*2
+		}
+		for high-low > 1 {
+			mid := low + (high-low)/2
+			ok, err := meets(mid)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				low = mid
+			} else {
+				high = mid
+			}
+		}
+
+		step := int(float64(low) * wobbleTolerance / wobbleProbes)
+		if step < 2 {
+			// low+1 is checked already
+			return low, nil
+		}
+		higher := 0
+		for i := wobbleProbes; i >= 1 && higher == 0; i-- {
+			ok, err := meets(low + i*step)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				higher = low + i*step
+			}
+		}
+		if higher == 0 {
+			return low, nil
+		}
+		low, high = higher, higher+step
+	}
+}
@@ -0,0 +1,239 @@
+package rearview
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// growingSeries is a price of every day growing 10% per year from 100
+func growingSeries(start string, years int) []DatePrice {
+	first := date(start)
+	datePrices := []DatePrice{}
+	for day := first; day.Before(first.AddDate(years, 0, 0)); day = day.AddDate(0, 0, 1) {
+		price := 100 * math.Pow(1.1, yearsBetween(first, day))
+		datePrices = append(datePrices, DatePrice{Date: day, OpenPrice: price, HighPrice: price, LowPrice: price, ClosePrice: price, AdjClose: price})
+	}
+	return datePrices
+}
+
+// testdataPrices are prices of testdata/prices.csv, a day of every 2 weeks from 1970 to 1975
+func testdataPrices(t *testing.T) []DatePrice {
+	t.Helper()
+	input, err := os.Open(filepath.Join("testdata", "prices.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	datePrices, err := ParseCSV(input, CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return datePrices
+}
+
+// TestCheckStrategyTrace checks the traces of every period of testdata/prices.csv against testdata/trace.golden,
+// go test -run TestCheckStrategyTrace -update rewrites it after an intended change
+func TestCheckStrategyTrace(t *testing.T) {
+	datePrices := testdataPrices(t)
+	config := Config{Capital: 333333, Run: 2, YearPerRun: 2, InflationRate: 1.016, CostPerYear: 16666, TaxRate: 0.1, Fee: Fee{Flat: 5}, Workers: 1}
+	buf := &bytes.Buffer{}
+	result, err := CheckStrategy(context.Background(), &config, datePrices, NewTraceLogger(buf, buf, LevelTrace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(buf, "success %d, failed %d, N/A %d, successful rate %.4f\n", result.SuccessCount, result.FailedCount, result.NACount, result.SuccessRate)
+
+	golden := filepath.Join("testdata", "trace.golden")
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("traces differ from %s, rerun with -update if the change is intended\n%s", golden, got)
+	}
+}
+
+func TestCheckStartsFee(t *testing.T) {
+	datePrices := growingSeries("2000-01-03", 3)
+	config := Config{Capital: 1000, Run: 2, YearPerRun: 1, InflationRate: 1, CostPerYear: 50}
+	check := func() Status {
+		result, err := CheckStarts(context.Background(), &config, datePrices, []time.Time{datePrices[0].Date}, NopLogger{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Periods[0].Status
+	}
+	if status := check(); status != Success {
+		t.Fatalf("without fee %s, want success", status)
+	}
+	// a sale of 50 needs 1500 before fee, more than the portfolio
+	config.Fee = Fee{Flat: 1450}
+	if status := check(); status != Failed {
+		t.Errorf("with a high fee %s, want failed", status)
+	}
+}
+
+func TestCheckStrategyInvalidRuns(t *testing.T) {
+	datePrices := growingSeries("2000-01-03", 1)
+	for _, config := range []Config{
+		{Capital: 1000, Run: -1, YearPerRun: 1, InflationRate: 1},
+		{Capital: 1000, Run: 2, YearPerRun: 0, InflationRate: 1},
+		{Capital: 1000, Run: 2, YearPerRun: 0, InflationRate: 1, Perpetual: true},
+	} {
+		if _, err := CheckStrategy(context.Background(), &config, datePrices, NopLogger{}); err == nil {
+			t.Errorf("Run %d, YearPerRun %d is checked without error", config.Run, config.YearPerRun)
+		}
+	}
+}
+
+func BenchmarkCheckStrategy(b *testing.B) {
+	// about 60 years of weekdays
+	datePrices := GenerateSeries(date("1960-01-04"), 15000, 0.07, 0.15, 1)
+	// defaults of the cli, one worker to time checking itself
+	config := Config{Capital: 333333, Run: 5, YearPerRun: 10, InflationRate: 1.016, CostPerYear: 16666, Workers: 1}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := CheckStrategy(context.Background(), &config, datePrices, NopLogger{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRunEnds compares finding run ends of every period by a binary search per boundary like CheckStarts
+// with finding them for all periods at once by findRunEnds like CheckStrategy
+func BenchmarkRunEnds(b *testing.B) {
+	datePrices := GenerateSeries(date("1960-01-04"), 15000, 0.07, 0.15, 1)
+	config := Config{Capital: 333333, Run: 5, YearPerRun: 10, InflationRate: 1.016, CostPerYear: 16666, Workers: 1}
+	starts := make([]time.Time, len(datePrices))
+	for i := range datePrices {
+		starts[i] = datePrices[i].Date
+	}
+	b.Run("binary search", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := CheckStarts(context.Background(), &config, datePrices, starts, NopLogger{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("findRunEnds", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := CheckStrategy(context.Background(), &config, datePrices, NopLogger{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestCheckStrategyPerpetual(t *testing.T) {
+	datePrices := growingSeries("2000-01-03", 5)
+	config := Config{Capital: 1000, YearPerRun: 1, InflationRate: 1, CostPerYear: 50, Perpetual: true}
+	result, err := CheckStrategy(context.Background(), &config, datePrices, NopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SuccessCount == 0 || result.FailedCount != 0 || result.YearsToRuin == nil {
+		t.Errorf("success %d, failed %d, years to ruin %v, want only success with 10%% growth", result.SuccessCount, result.FailedCount, result.YearsToRuin)
+	}
+
+	// 0 years per run used to divide by 0 in perpetualRuns
+	config.YearPerRun = 0
+	if _, err := CheckStrategy(context.Background(), &config, datePrices, NopLogger{}); err == nil {
+		t.Error("0 years per run is checked without error")
+	}
+}
+
+func TestCheckInPeriodWithoutData(t *testing.T) {
+	config := Config{Capital: 1000, Run: 2, YearPerRun: 1, InflationRate: 1, CostPerYear: 50}
+	if period := checkInPeriod(&config, nil, nil, NopLogger{}); period.Status != NA {
+		t.Errorf("period without data is %s, want N/A", period.Status)
+	}
+}
+
+func TestCheckMonteCarloShortData(t *testing.T) {
+	config := Config{Capital: 1000, Run: 2, YearPerRun: 1, InflationRate: 1, CostPerYear: 50}
+	for _, datePrices := range [][]DatePrice{nil, growingSeries("2000-01-03", 1)[:1]} {
+		if _, err := CheckMonteCarlo(context.Background(), &config, datePrices, 10, 1, NopLogger{}); err == nil {
+			t.Errorf("monte carlo of %d days is checked without error", len(datePrices))
+		}
+	}
+}
+
+func TestCheckMonteCarloAccumulate(t *testing.T) {
+	datePrices := growingSeries("2000-01-03", 2)
+	config := Config{Capital: 1000, Run: 2, YearPerRun: 1, InflationRate: 1, CostPerYear: 50, AccumulateYears: 3, Contribution: 100}
+	result, err := CheckMonteCarlo(context.Background(), &config, datePrices, 10, 1, NopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// paths are long enough for years of saving and every run
+	if result.NACount != 0 {
+		t.Errorf("%d trials are N/A, want none", result.NACount)
+	}
+}
+
+func TestMaxCost(t *testing.T) {
+	tests := []struct {
+		name  string
+		meets func(cost int) bool
+		want  int
+	}{
+		{name: "falling", meets: func(cost int) bool { return cost <= 777 }, want: 777},
+		// the binary search ends at 1014 below the hole, a cost a little higher meets target again
+		{name: "wobbling", meets: func(cost int) bool { return cost <= 1030 && (cost < 1015 || cost > 1017) }, want: 1030},
+	}
+	for _, test := range tests {
+		cost, err := maxCost(1000, func(cost int) (bool, error) { return test.meets(cost), nil })
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if cost != test.want {
+			t.Errorf("%s: max cost %d, want %d", test.name, cost, test.want)
+		}
+	}
+}
+
+// TestMaxSafeCostSWR is -swr of the cli, every period of testdata survives the cost found
+func TestMaxSafeCostSWR(t *testing.T) {
+	datePrices := testdataPrices(t)
+	config := Config{Capital: 333333, Run: 2, YearPerRun: 2, InflationRate: 1.016, CostPerYear: 16666, Workers: 1, Strategy: ConstantStrategy{}}
+	cost, err := MaxSafeCost(context.Background(), &config, datePrices, 1, NopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CostPerYear = cost
+	result, err := CheckStrategy(context.Background(), &config, datePrices, NopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost <= 0 || result.FailedCount != 0 {
+		t.Errorf("safe cost %d fails %d periods, want a positive cost without failure", cost, result.FailedCount)
+	}
+}
+
+func TestCheckCapitalRangeInvalid(t *testing.T) {
+	datePrices := growingSeries("2000-01-03", 3)
+	config := Config{Capital: 1000, Run: 2, YearPerRun: 1, InflationRate: 1, CostPerYear: 50}
+	if _, err := CheckCapitalRange(context.Background(), &config, datePrices, 2000, 1000, 10, 1, NopLogger{}); err == nil {
+		t.Error("capital range 2000 to 1000 is checked without error")
+	}
+	config.Run, config.Perpetual = 0, true
+	if _, err := CheckCapitalRange(context.Background(), &config, datePrices, 1000, 2000, 10, 1, NopLogger{}); err == nil {
+		t.Error("perpetual capital range is checked without error")
+	}
+}
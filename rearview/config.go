@@ -0,0 +1,315 @@
+package rearview
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the plan to check
+type Config struct {
+	Capital    int64
+	Run        int
+	YearPerRun int
+	// InflationRate is 1 + inflation per year like 1.016 for 1.6%, below 1 is deflation
+	InflationRate float64
+	CostPerYear   int
+	// PriceField is the price used to sell and value the portfolio, see IsPriceField
+	PriceField string
+	// BuyPriceField is the price of the initial purchase, it's PriceField if it's empty,
+	// later trades like rebalancing and reinvesting dividends use PriceField
+	BuyPriceField string
+	// Workers is how many start days are checked in parallel
+	Workers     int
+	TaxRate     float64
+	Fee         Fee
+	WholeShares bool
+	// FIFO realizes gains of a sale against the oldest lots of shares first instead of their average cost,
+	// the initial purchase, contributions and reinvested dividends are each a lot
+	FIFO bool
+	// CPI computes inflation instead of InflationRate if it's not nil
+	CPI []DateCPI
+	// Strategy is FixedStrategy if it's nil
+	Strategy Strategy
+	// Bonds are held along with stocks if it's not nil,
+	// stocks are rebalanced to StockWeight of capital at start of each run
+	Bonds       []DatePrice
+	StockWeight float64
+	// BondsFirst withdraws from bonds before stocks instead of in proportion to their value
+	BondsFirst bool
+	// CashWeight of capital is held as cash earning CashReturn per year,
+	// cost of living is drawn from cash before selling stocks and bonds
+	CashWeight float64
+	CashReturn float64
+	// ReserveYears of cost of living per year are held as cash too, it's drawn first like CashWeight,
+	// and topped up by selling shares after the withdrawal of each run while capital is above the inflation adjusted principal
+	ReserveYears float64
+	// Spending[i] multiplies cost per year in year i of a period, years after the last use the last multiplier
+	Spending []float64
+	// Monthly spreads the withdrawal of a run across its months instead of withdrawing it at once,
+	// Strategy still decides whether the run succeeds and how much it withdraws
+	Monthly bool
+	// Pessimistic sells at the lowest low price of the run, or the low price of each month if Monthly,
+	// instead of the day Strategy picks
+	Pessimistic bool
+	// MaxGap is the most calendar days the closest day of a run boundary may be from it,
+	// a period is N/A if it's farther, 0 is no limit
+	MaxGap int
+	// DividendYield per year is reinvested in stocks at run boundaries and withdrawals and counts in capital between them,
+	// for prices without dividends like the index itself instead of adjusted close
+	DividendYield float64
+	// Phases are costs per year of the first years of a period in order, CostPerYear is the cost after them
+	Phases []Phase
+	// Income per year like a pension is paid from year IncomeStartYear of a period,
+	// 0 is the first year, it's inflation adjusted and reduces the cost of living
+	Income          int
+	IncomeStartYear int
+	// AnniversaryMonth and AnniversaryDay put run boundaries on this day of a year if AnniversaryMonth is not 0,
+	// the first run ends YearPerRun years after the first such day on or after the start day, so it's up to a year longer,
+	// instead of boundaries on anniversaries of the start day. A boundary on a day without data like Jan 1 is the closest day with data,
+	// the earlier one if two are equally near, e.g. Dec 31 before Jan 2
+	AnniversaryMonth time.Month
+	AnniversaryDay   int
+	// Perpetual checks runs of a period until data ends instead of Run runs,
+	// a period succeeds if it survives the whole data after at least one run, see StrategyResult.YearsToRuin
+	Perpetual bool
+	// Partial checks the last run of a period until data ends if data ends before the run does,
+	// cost of living and withdrawals of that run are in proportion to how much of the run it is,
+	// instead of leaving the period N/A
+	Partial bool
+	// NAAsFailed counts N/A periods like failed ones in the successful rate instead of leaving them out,
+	// so start days too late for every run lower the rate
+	NAAsFailed bool
+	// Trajectory records the portfolio at every run boundary in PeriodResult.Trajectory
+	Trajectory bool
+	// Ledger records every sale of a period in PeriodResult.Sales
+	Ledger bool
+	// IRR computes PeriodResult.IRR of every period
+	IRR bool
+	// AccumulateYears are years of saving before runs begin, Contribution per year is invested at the end of each of them
+	// by the allocation, it's inflation adjusted like CostPerYear. Years of Phases, Spending and Income count from when runs begin.
+	AccumulateYears int
+	Contribution    int
+	// RealTrace traces dollar amounts in dollars of the first day of a period instead of nominal dollars,
+	// amounts of a run are deflated by inflation to its end like its cost of living, so they add up within the run.
+	// Only traces change, the simulation is nominal either way
+	RealTrace bool
+	// OnPeriod is called with the config checked, like one of a sweep, and the result of a period as soon as it's checked if it's not nil,
+	// periods come in the order they're done, calls are never concurrent. StrategyResult.Periods is nil then, periods aren't kept
+	OnPeriod func(config *Config, period PeriodResult)
+	// Progress is called with how many start days or trials are checked of total as they're done if it's not nil,
+	// calls are never concurrent
+	Progress func(done, total int)
+}
+
+// Phase is the cost per year of some years of a period
+type Phase struct {
+	Years       int
+	CostPerYear int
+}
+
+// validateRuns makes sure there's at least a run of at least a year, Run doesn't matter with Perpetual
+func (c *Config) validateRuns() error {
+	if c.YearPerRun < 1 {
+		return fmt.Errorf("invalid years per run %d, it must be at least 1", c.YearPerRun)
+	}
+	if c.Run < 1 && !c.Perpetual {
+		return fmt.Errorf("invalid run count %d, it must be at least 1", c.Run)
+	}
+	return nil
+}
+
+// price returns the price used to sell and value the portfolio
+func (c *Config) price(datePrice *DatePrice) float64 {
+	return priceOf(c.PriceField, datePrice)
+}
+
+// buyPrice returns the price of the initial purchase
+func (c *Config) buyPrice(datePrice *DatePrice) float64 {
+	if c.BuyPriceField == "" {
+		return c.price(datePrice)
+	}
+	return priceOf(c.BuyPriceField, datePrice)
+}
+
+// priceOf returns the price of field, see IsPriceField
+func priceOf(field string, datePrice *DatePrice) float64 {
+	switch field {
+	case "open":
+		return datePrice.OpenPrice
+	case "low":
+		return datePrice.LowPrice
+	case "close":
+		return datePrice.ClosePrice
+	case "adjclose":
+		return datePrice.AdjClose
+	default:
+		return datePrice.HighPrice
+	}
+}
+
+// inflation returns how much prices grow from day from to day to, which are years apart.
+// It's the ratio of cpi of these days if cpi is given, otherwise the constant inflation rate compounded,
+// it's not ok if cpi doesn't cover either day.
+func (c *Config) inflation(from, to time.Time, years float64) (float64, bool) {
+	if c.CPI == nil {
+		return math.Pow(c.InflationRate, years), true
+	}
+	fromIndex, fFound := findClosestCPI(from, c.CPI)
+	toIndex, tFound := findClosestCPI(to, c.CPI)
+	if !fFound || !tFound {
+		return 0, false
+	}
+	return c.CPI[toIndex].CPI / c.CPI[fromIndex].CPI, true
+}
+
+// traced is amount to trace in a run of inflationRate since the first day of a period,
+// it's in dollars of the first day if RealTrace is set
+func (c *Config) traced(amount, inflationRate float64) float64 {
+	if c.RealTrace {
+		return amount / inflationRate
+	}
+	return amount
+}
+
+// reserve is ReserveYears of the cost of living per year of run, inflationRate adjusted
+func (c *Config) reserve(run int, inflationRate float64) float64 {
+	if c.ReserveYears == 0 {
+		return 0
+	}
+	return c.ReserveYears * c.costOfLiving(run, inflationRate) / float64(c.YearPerRun)
+}
+
+// runEnd is the end day of run of the period starting from start, see AnniversaryMonth and AccumulateYears
+func (c *Config) runEnd(start time.Time, run int) time.Time {
+	if c.AnniversaryMonth == 0 {
+		return anniversary(start, c.AccumulateYears+(run+1)*c.YearPerRun)
+	}
+	start = anniversary(start, c.AccumulateYears)
+	on := func(year int) time.Time {
+		date := time.Date(year, c.AnniversaryMonth, c.AnniversaryDay, 0, 0, 0, 0, start.Location())
+		if date.Month() != c.AnniversaryMonth {
+			// Feb 29 in a year which is not a leap year is Feb 28
+			date = date.AddDate(0, 0, -date.Day())
+		}
+		return date
+	}
+	year := start.Year()
+	if on(year).Before(start) {
+		year++
+	}
+	return on(year + (run+1)*c.YearPerRun)
+}
+
+// yearsBetween is the years from day from to day to
+func yearsBetween(from, to time.Time) float64 {
+	return to.Sub(from).Hours() / 24 / 365.25
+}
+
+// daysBetween is the calendar days between a and b in either order
+func daysBetween(a, b time.Time) int {
+	days := int(math.Round(b.Sub(a).Hours() / 24))
+	if days < 0 {
+		return -days
+	}
+	return days
+}
+
+// costOfLiving is the cost of living of the run with phases, the spending schedule, income and inflation considered
+func (c *Config) costOfLiving(run int, inflationRate float64) float64 {
+	cost := float64(0)
+	for year := run * c.YearPerRun; year < (run+1)*c.YearPerRun; year++ {
+		yearCost := c.costPerYear(year) * c.spending(year)
+		if year >= c.IncomeStartYear {
+			// income above the cost of a year is not saved
+			yearCost = math.Max(yearCost-float64(c.Income), 0)
+		}
+		cost += yearCost
+	}
+	return cost * inflationRate
+}
+
+// costPerYear is the cost of living of year of a period before inflation,
+// it's the cost of the phase of the year, or CostPerYear after all phases
+func (c *Config) costPerYear(year int) float64 {
+	for _, phase := range c.Phases {
+		if year < phase.Years {
+			return float64(phase.CostPerYear)
+		}
+		year -= phase.Years
+	}
+	return float64(c.CostPerYear)
+}
+
+// spending is the multiplier of cost per year in year of a period
+func (c *Config) spending(year int) float64 {
+	switch {
+	case c.Spending == nil:
+		return 1
+	case year < len(c.Spending):
+		return c.Spending[year]
+	default:
+		return c.Spending[len(c.Spending)-1]
+	}
+}
+
+// shares truncates to whole shares if fractional shares are not allowed
+func (c *Config) shares(shares float64) float64 {
+	if c.WholeShares {
+		return math.Floor(shares)
+	}
+	return shares
+}
+
+func (c *Config) strategy() Strategy {
+	if c.Strategy == nil {
+		return FixedStrategy{}
+	}
+	return c.Strategy
+}
+
+// workers is at least 1
+func (c *Config) workers() int {
+	if c.Workers < 1 {
+		return 1
+	}
+	return c.Workers
+}
+
+// Fee of one trade, a flat amount plus a percentage of the trade value
+type Fee struct {
+	Flat    float64
+	Percent float64
+}
+
+// Of returns the fee of a trade worth value
+func (f *Fee) Of(value float64) float64 {
+	return f.Flat + value*f.Percent
+}
+
+func (f *Fee) String() string {
+	return fmt.Sprintf("%g+%g%%", f.Flat, f.Percent*100)
+}
+
+// Set parses flat amount like 10, percentage like 0.5%, or both like 10+0.5%
+func (f *Fee) Set(value string) error {
+	*f = Fee{}
+	for _, part := range strings.Split(value, "+") {
+		isPercent := strings.HasSuffix(part, "%")
+		amount, err := strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64)
+		if err != nil || amount < 0 {
+			return fmt.Errorf("invalid fee %q", part)
+		}
+		if isPercent {
+			f.Percent += amount / 100
+		} else {
+			f.Flat += amount
+		}
+	}
+	if f.Percent >= 1 {
+		return fmt.Errorf("fee percentage %s must be less than 100%%", value)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package rearview
+
+import "testing"
+
+func TestRunEnd(t *testing.T) {
+	tests := []struct {
+		config Config
+		want   []string
+	}{
+		// boundaries stay on anniversaries of the start day, Feb 28 in years which are not leap years
+		{Config{Run: 4, YearPerRun: 1}, []string{"1973-02-28", "1974-02-28", "1975-02-28", "1976-02-29"}},
+		{Config{Run: 3, YearPerRun: 4}, []string{"1976-02-29", "1980-02-29", "1984-02-29"}},
+		{Config{Run: 2, YearPerRun: 3}, []string{"1975-02-28", "1978-02-28"}},
+		{Config{Run: 2, YearPerRun: 2, AccumulateYears: 1}, []string{"1975-02-28", "1977-02-28"}},
+		// on or after the start day, Feb 29 in leap years
+		{Config{Run: 2, YearPerRun: 4, AnniversaryMonth: 2, AnniversaryDay: 29}, []string{"1976-02-29", "1980-02-29"}},
+		{Config{Run: 2, YearPerRun: 1, AnniversaryMonth: 2, AnniversaryDay: 29}, []string{"1973-02-28", "1974-02-28"}},
+	}
+	start := date("1972-02-29")
+	for i, test := range tests {
+		for run, want := range test.want {
+			if got := test.config.runEnd(start, run); !got.Equal(date(want)) {
+				t.Errorf("test %d: run %d from 1972-02-29 ends %s, want %s", i, run, toyyyymmdd(got), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package rearview
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// skipBOM skips the utf-8 byte order mark excel writes at the start of a csv
+func skipBOM(input io.Reader) io.Reader {
+	buffered := bufio.NewReader(input)
+	if bom, err := buffered.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		buffered.Discard(3)
+	}
+	return buffered
+}
+
+// isBlank reports whether every field of line is empty or spaces, like a trailing line of commas
+func isBlank(line []string) bool {
+	for _, field := range line {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// columnIndexes maps column names of header to their index
+func columnIndexes(header []string) map[string]int {
+	indexes := map[string]int{}
+	for i, column := range header {
+		if _, ok := indexes[column]; !ok {
+			indexes[column] = i
+		}
+	}
+	return indexes
+}
+
+// columnIndex finds the index of the named column in header
+func columnIndex(indexes map[string]int, header []string, name string) (int, error) {
+	index, ok := indexes[name]
+	if !ok {
+		return -1, fmt.Errorf("missing column %q, columns are %s", name, strings.Join(header, ", "))
+	}
+	return index, nil
+}
+
+// CSVOptions controls how ParseCSV reads prices
+type CSVOptions struct {
+	// DateColumn is the name of the date column, it's Date if it's empty
+	DateColumn string
+	// PriceColumn is the name of the only column prices are read from if it's not empty,
+	// every price field of DatePrice is set to it
+	PriceColumn string
+	// Comma separates fields, it's ',' if it's 0
+	Comma rune
+	// Decimal is the decimal separator of prices, it's '.' if it's 0
+	Decimal rune
+	// CarryMissing uses prices of the previous row for a row with missing prices instead of skipping it
+	CarryMissing bool
+	// NoHeader parses the first row as data, columns are then in the order of defaultHeader
+	NoHeader bool
+	// ByIndex reads the date from column DateIndex and every price field from column PriceIndex,
+	// counted from 0, instead of finding columns by name, for files of names which don't fit
+	ByIndex    bool
+	DateIndex  int
+	PriceIndex int
+	// CloseAsAdjClose reads Adj Close from Close if there's no Adj Close column, like files of stooq which don't adjust,
+	// for a price of Adj Close which isn't used, otherwise it's a missing column
+	CloseAsAdjClose bool
+	// Unsorted allows dates in any order, like for sorting them afterwards,
+	// otherwise a date which is not after the date of the row before is an error of its line
+	Unsorted bool
+	// OnRepeatedHeader is called with the line number of a row of column names again in the middle if it's not nil,
+	// the row is skipped either way, so a mistake of copy and paste can be warned about
+	OnRepeatedHeader func(line int)
+}
+
+// defaultHeader is the columns of a file without header, it's the order of yahoo finance
+var defaultHeader = []string{"Date", "Open", "High", "Low", "Close", "Adj Close", "Volume"}
+
+// isMissing tells if a price field is yahoo's placeholder of a day without data
+func isMissing(field string) bool {
+	field = strings.TrimSpace(field)
+	return field == "" || field == "null"
+}
+
+// dateLayouts are date formats of providers, the first matches the first row is used for the whole file
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"01/02/2006",
+	"02-Jan-2006",
+	"Jan 02, 2006",
+	"20060102",
+}
+
+// detectDateLayout finds the layout of date in dateLayouts
+func detectDateLayout(date string) (string, error) {
+	for _, layout := range dateLayouts {
+		if _, err := time.Parse(layout, date); err == nil {
+			return layout, nil
+		}
+	}
+	return "", fmt.Errorf("Date %q is not in any known format like yyyy-mm-dd, mm/dd/yyyy or dd-Mon-yyyy", date)
+}
+
+// parsePrice parses price with options.Decimal as the decimal separator
+func (options *CSVOptions) parsePrice(field string) (float64, error) {
+	if options.Decimal != 0 && options.Decimal != '.' {
+		field = strings.Replace(field, string(options.Decimal), ".", 1)
+	}
+	return strconv.ParseFloat(field, 64)
+}
+
+// ParseCSV parses daily prices, expected columns:
+// Date Open High Low Close Adj Close
+// It's the format yahoo finace provided, columns are found by their name in any order,
+// Adj Close is Close if it's missing and options.CloseAsAdjClose is set,
+// or only options.DateColumn and options.PriceColumn are needed if they're set.
+// Columns are at options.DateIndex and options.PriceIndex instead if options.ByIndex is set.
+// Format of Date is detected from the first row, see dateLayouts.
+// A price must be positive.
+// A row with any of the price columns empty or null is skipped,
+// or carries prices of the previous row if options.CarryMissing is set.
+// The first row is column names unless options.NoHeader is set,
+// a row of column names again in the middle, like files concatenated with cat, is skipped, see options.OnRepeatedHeader.
+// A utf-8 byte order mark at the start and blank rows are skipped too, other rows must have as many fields as the first row.
+// Dates must be ascending unless options.Unsorted is set.
+func ParseCSV(input io.Reader, options CSVOptions) ([]DatePrice, error) {
+	reader := csv.NewReader(skipBOM(input))
+	if options.Comma != 0 {
+		reader.Comma = options.Comma
+	}
+	// blank rows have fewer fields, rows are checked after skipping them
+	reader.FieldsPerRecord = -1
+
+	firstRow, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	header, firstLine := firstRow, 2
+	if options.NoHeader {
+		header, firstLine = defaultHeader, 1
+		if len(firstRow) < len(header) {
+			header = header[:len(firstRow)]
+		}
+	}
+	if options.ByIndex {
+		return parseRows(reader, options, firstRow, header, firstLine, options.DateIndex, [5]int{
+			options.PriceIndex, options.PriceIndex, options.PriceIndex, options.PriceIndex, options.PriceIndex,
+		})
+	}
+	indexes := columnIndexes(header)
+	dateColumn := options.DateColumn
+	if dateColumn == "" {
+		dateColumn = "Date"
+	}
+	dateIndex, err := columnIndex(indexes, header, dateColumn)
+	if err != nil {
+		if _, dateErr := detectDateLayout(firstRow[0]); !options.NoHeader && dateErr == nil {
+			return nil, fmt.Errorf("%w, the first row looks like data instead of column names, the file may have no header", err)
+		}
+		return nil, err
+	}
+	priceColumns := [5]string{"Open", "High", "Low", "Close", "Adj Close"}
+	if options.PriceColumn != "" {
+		for i := range priceColumns {
+			priceColumns[i] = options.PriceColumn
+		}
+	} else if _, ok := indexes["Adj Close"]; !ok && options.CloseAsAdjClose {
+		// stooq and others don't adjust, close is the best we have
+		priceColumns[4] = "Close"
+	}
+	priceIndexes := [5]int{}
+	for i, name := range priceColumns {
+		priceIndexes[i], err = columnIndex(indexes, header, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parseRows(reader, options, firstRow, header, firstLine, dateIndex, priceIndexes)
+}
+
+// parseRows parses rows of ParseCSV from firstRow with the date at dateIndex and price fields at priceIndexes,
+// firstRow is data if firstLine is 1
+func parseRows(reader *csv.Reader, options CSVOptions, firstRow, header []string, firstLine, dateIndex int, priceIndexes [5]int) ([]DatePrice, error) {
+	for _, index := range append([]int{dateIndex}, priceIndexes[:]...) {
+		if index < 0 || index >= len(firstRow) {
+			return nil, fmt.Errorf("column index %d is out of the %d columns of the first row", index, len(firstRow))
+		}
+	}
+	priceColumns := [5]string{}
+	for i, index := range priceIndexes {
+		if index < len(header) {
+			priceColumns[i] = header[index]
+		} else {
+			priceColumns[i] = fmt.Sprintf("column %d", index)
+		}
+	}
+	headerDate := ""
+	if dateIndex < len(header) {
+		headerDate = header[dateIndex]
+	}
+
+	// rows are parsed into values right away, so the reader can reuse its record,
+	// header is not used after this
+	reader.ReuseRecord = true
+	// about 16 years of trading days
+	datePrices := make([]DatePrice, 0, 4096)
+	dateLayout, previous := "", time.Time{}
+	var err error
+	for lineNumber := firstLine; ; lineNumber++ {
+		line := firstRow
+		if lineNumber > 1 {
+			line, err = reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+		}
+
+		if isBlank(line) {
+			continue
+		}
+		if len(line) != len(firstRow) {
+			return nil, fmt.Errorf("line %d: %d fields instead of %d of the first row", lineNumber, len(line), len(firstRow))
+		}
+		if line[dateIndex] == headerDate {
+			// header of the next concatenated file
+			if options.OnRepeatedHeader != nil {
+				options.OnRepeatedHeader(lineNumber)
+			}
+			continue
+		}
+		if dateLayout == "" {
+			dateLayout, err = detectDateLayout(line[dateIndex])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+		}
+		date, err := time.Parse(dateLayout, line[dateIndex])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: Date %q is not in the format %s of the first row", lineNumber, line[dateIndex], dateLayout)
+		}
+		if !options.Unsorted && !previous.IsZero() && !date.After(previous) {
+			return nil, fmt.Errorf("line %d: date %s is not after %s of the row before, dates must be sorted ascending without duplicates", lineNumber, toyyyymmdd(date), toyyyymmdd(previous))
+		}
+		previous = date
+
+		missing := false
+		for _, index := range priceIndexes {
+			missing = missing || isMissing(line[index])
+		}
+		if missing {
+			if options.CarryMissing && len(datePrices) > 0 {
+				datePrice := datePrices[len(datePrices)-1]
+				datePrice.Date = date
+				datePrices = append(datePrices, datePrice)
+			}
+			continue
+		}
+
+		prices := [5]float64{}
+		for i, index := range priceIndexes {
+			prices[i], err = options.parsePrice(line[index])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s %q is not a number", lineNumber, priceColumns[i], line[index])
+			}
+			// shares bought at 0 are infinite, a price of stocks or bonds is never 0 or less
+			if prices[i] <= 0 {
+				return nil, fmt.Errorf("line %d: %s %q on %s is not a positive price", lineNumber, priceColumns[i], line[index], toyyyymmdd(date))
+			}
+		}
+
+		datePrice := DatePrice{
+			Date:       date,
+			OpenPrice:  prices[0],
+			HighPrice:  prices[1],
+			LowPrice:   prices[2],
+			ClosePrice: prices[3],
+			AdjClose:   prices[4],
+		}
+		datePrices = append(datePrices, datePrice)
+	}
+
+	return datePrices, nil
+}
+
+// ParseCPI parses cpi, expected columns:
+// Date CPI
+// It's the format FRED provided, yearly or monthly cpi are both fine
+func ParseCPI(input io.Reader) ([]DateCPI, error) {
+	reader := csv.NewReader(skipBOM(input))
+
+	// skip column name
+	_, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	cpi := []DateCPI{}
+	for lineNumber := 2; ; lineNumber++ {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if len(line) < 2 {
+			return nil, fmt.Errorf("line %d: expect date and cpi", lineNumber)
+		}
+
+		year, month, day := 0, 0, 0
+		if _, err := fmt.Sscanf(line[0], "%d-%d-%d", &year, &month, &day); err != nil {
+			return nil, fmt.Errorf("line %d: Date %q is not yyyy-mm-dd: %w", lineNumber, line[0], err)
+		}
+		value, err := strconv.ParseFloat(line[1], 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("line %d: CPI %q is not a positive number", lineNumber, line[1])
+		}
+
+		date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+		if len(cpi) > 0 && !date.After(cpi[len(cpi)-1].Date) {
+			return nil, fmt.Errorf("line %d: date %s is not after %s, dates must be sorted ascending", lineNumber, line[0], toyyyymmdd(cpi[len(cpi)-1].Date))
+		}
+		cpi = append(cpi, DateCPI{
+			Date: date,
+			CPI:  value,
+		})
+	}
+
+	return cpi, nil
+}
+
+// ParseSpending parses a spending schedule, expected columns:
+// Year Multiplier
+// Year is the offset from the start of a period, 0 is the first year.
+// A missing year uses the multiplier of the year before it.
+func ParseSpending(input io.Reader) ([]float64, error) {
+	reader := csv.NewReader(skipBOM(input))
+
+	// skip column name
+	_, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	spending := []float64{}
+	for lineNumber := 2; ; lineNumber++ {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if len(line) < 2 {
+			return nil, fmt.Errorf("line %d: expect year and multiplier", lineNumber)
+		}
+
+		year, err := strconv.Atoi(line[0])
+		if err != nil || year < len(spending) || (len(spending) == 0 && year != 0) {
+			return nil, fmt.Errorf("line %d: Year %q must be ascending from 0", lineNumber, line[0])
+		}
+		multiplier, err := strconv.ParseFloat(line[1], 64)
+		if err != nil || multiplier < 0 {
+			return nil, fmt.Errorf("line %d: Multiplier %q is not a non-negative number", lineNumber, line[1])
+		}
+		for len(spending) < year {
+			spending = append(spending, spending[len(spending)-1])
+		}
+		spending = append(spending, multiplier)
+	}
+
+	return spending, nil
+}
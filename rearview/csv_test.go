@@ -0,0 +1,138 @@
+package rearview
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseCSV(t *testing.T) {
+	want := []DatePrice{
+		{Date: date("2021-01-04"), OpenPrice: 10, HighPrice: 12, LowPrice: 9, ClosePrice: 11, AdjClose: 10.5},
+		{Date: date("2021-01-05"), OpenPrice: 11, HighPrice: 13, LowPrice: 10, ClosePrice: 12, AdjClose: 11.5},
+	}
+	tests := []struct {
+		name    string
+		input   string
+		options CSVOptions
+	}{
+		{
+			name: "yahoo",
+			input: `Date,Open,High,Low,Close,Adj Close,Volume
+2021-01-04,10,12,9,11,10.5,100
+2021-01-05,11,13,10,12,11.5,100
+`,
+		},
+		{
+			name: "semicolons and decimal commas",
+			input: `Date;Open;High;Low;Close;Adj Close;Volume
+2021-01-04;10;12;9;11;10,5;100
+2021-01-05;11;13;10;12;11,5;100
+`,
+			options: CSVOptions{Comma: ';', Decimal: ','},
+		},
+	}
+	for _, test := range tests {
+		datePrices, err := ParseCSV(strings.NewReader(test.input), test.options)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(datePrices) != len(want) {
+			t.Errorf("%s: %d days, want %d", test.name, len(datePrices), len(want))
+			continue
+		}
+		for i := range want {
+			if datePrices[i] != want[i] {
+				t.Errorf("%s: day %d = %+v, want %+v", test.name, i, datePrices[i], want[i])
+			}
+		}
+	}
+}
+
+func TestParseCSVUnsorted(t *testing.T) {
+	// the skipped row of null prices still counts as a line
+	input := `Date,Open,High,Low,Close,Adj Close,Volume
+2021-01-04,10,12,9,11,10.5,100
+2021-01-05,null,null,null,null,null,0
+2021-01-06,11,13,10,12,11.5,100
+2021-01-05,11,13,10,12,11.5,100
+`
+	_, err := ParseCSV(strings.NewReader(input), CSVOptions{})
+	if err == nil || !strings.HasPrefix(err.Error(), "line 5: ") {
+		t.Errorf("error %v, want one of line 5", err)
+	}
+	datePrices, err := ParseCSV(strings.NewReader(input), CSVOptions{Unsorted: true})
+	if err != nil || len(datePrices) != 3 {
+		t.Errorf("Unsorted parsed %d days, %v, want 3 days", len(datePrices), err)
+	}
+}
+
+func BenchmarkParseCSV(b *testing.B) {
+	var input strings.Builder
+	input.WriteString("Date,Open,High,Low,Close,Adj Close,Volume\n")
+	for _, day := range GenerateSeries(date("1960-01-04"), 15000, 0.07, 0.15, 1) {
+		fmt.Fprintf(&input, "%s,%f,%f,%f,%f,%f,1000\n", toyyyymmdd(day.Date), day.OpenPrice, day.HighPrice, day.LowPrice, day.ClosePrice, day.AdjClose)
+	}
+	content := input.String()
+	// reading records alone, a new record of every row is how ParseCSV read before it reused them
+	for _, reuse := range []bool{false, true} {
+		name := "new records"
+		if reuse {
+			name = "reused records"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				reader := csv.NewReader(strings.NewReader(content))
+				reader.ReuseRecord = reuse
+				for {
+					if _, err := reader.Read(); err == io.EOF {
+						break
+					} else if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+	b.Run("ParseCSV", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ParseCSV(strings.NewReader(content), CSVOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestParseCSVRepeatedHeader(t *testing.T) {
+	input := `Date,Open,High,Low,Close,Adj Close,Volume
+2021-01-04,10,12,9,11,10.5,100
+Date,Open,High,Low,Close,Adj Close,Volume
+2021-01-05,11,13,10,12,11.5,100
+`
+	lines := []int{}
+	datePrices, err := ParseCSV(strings.NewReader(input), CSVOptions{OnRepeatedHeader: func(line int) { lines = append(lines, line) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datePrices) != 2 || len(lines) != 1 || lines[0] != 3 {
+		t.Errorf("%d days with column names again on lines %v, want 2 days and line 3", len(datePrices), lines)
+	}
+}
+
+func TestParseCSVWithoutAdjClose(t *testing.T) {
+	input := `Date,Open,High,Low,Close,Volume
+2021-01-04,10,12,9,11,100
+`
+	if _, err := ParseCSV(strings.NewReader(input), CSVOptions{}); err == nil || !strings.Contains(err.Error(), `"Adj Close"`) {
+		t.Errorf("error %v, want one of the missing Adj Close", err)
+	}
+	datePrices, err := ParseCSV(strings.NewReader(input), CSVOptions{CloseAsAdjClose: true})
+	if err != nil || len(datePrices) != 1 || datePrices[0].AdjClose != 11 {
+		t.Errorf("CloseAsAdjClose parsed %+v, %v, want Adj Close of Close 11", datePrices, err)
+	}
+}
@@ -0,0 +1,352 @@
+package rearview
+
+import (
+	"math"
+	"time"
+)
+
+// holding is shares of one asset and their average cost, the basis of capital gains
+type holding struct {
+	name      string
+	shares    float64
+	costBasis float64
+	// lots are shares by the price they're bought at, the oldest first, only with Config.FIFO
+	lots []lot
+}
+
+// lot is shares bought at once
+type lot struct {
+	shares float64
+	price  float64
+}
+
+func (h *holding) value(price float64) float64 {
+	return h.shares * price
+}
+
+// buy spends amount on shares at price, the average cost includes the new shares
+func (h *holding) buy(config *Config, amount, price float64) float64 {
+	bought := config.shares(amount / price)
+	if h.shares == 0 {
+		h.costBasis = price
+	} else if bought > 0 {
+		h.costBasis = (h.shares*h.costBasis + bought*price) / (h.shares + bought)
+	}
+	h.shares += bought
+	if config.FIFO && bought > 0 {
+		h.lots = append(h.lots, lot{bought, price})
+	}
+	return bought
+}
+
+// sale is a sale of shares to get money
+type sale struct {
+	name   string
+	shares float64
+	price  float64
+	// costBasis is the average cost of the shares
+	costBasis float64
+	// remained shares of the asset after the sale
+	remained float64
+	tax      float64
+	fee      float64
+}
+
+// netPerShare is what we get from a share of cost after tax of gains and the percentage fee
+func netPerShare(config *Config, cost, price float64) float64 {
+	gainPerShare := math.Max(price-cost, 0)
+	return price*(1-config.Fee.Percent) - gainPerShare*config.TaxRate
+}
+
+// netOf is what we get from shares after tax of gains and the percentage fee, before the flat fee,
+// gains are of the average cost, or of the oldest lots with Config.FIFO
+func (h *holding) netOf(config *Config, shares, price float64) float64 {
+	if !config.FIFO {
+		return shares * netPerShare(config, h.costBasis, price)
+	}
+	net := float64(0)
+	for _, lot := range h.lots {
+		if shares <= 0 {
+			break
+		}
+		sold := math.Min(shares, lot.shares)
+		net += sold * netPerShare(config, lot.price, price)
+		shares -= sold
+	}
+	return net
+}
+
+// sharesFor is how many shares are sold to get net after tax of gains and the percentage fee,
+// it's infinite if the oldest lots of Config.FIFO can't get it
+func (h *holding) sharesFor(config *Config, net, price float64) float64 {
+	if !config.FIFO {
+		return net / netPerShare(config, h.costBasis, price)
+	}
+	shares := float64(0)
+	for _, lot := range h.lots {
+		perShare := netPerShare(config, lot.price, price)
+		if all := lot.shares * perShare; all < net {
+			shares, net = shares+lot.shares, net-all
+			continue
+		}
+		return shares + net/perShare
+	}
+	return math.Inf(1)
+}
+
+// sell sells more than amount to pay tax of gains and trading fee, what we get after them is amount.
+// It returns the shortfall if there are not enough shares, nothing is sold then.
+func (h *holding) sell(config *Config, amount, price float64) (sale, float64, bool) {
+	soldShares := config.shares(h.sharesFor(config, amount+config.Fee.Flat, price))
+	if soldShares > h.shares {
+		return sale{}, amount - (h.netOf(config, h.shares, price) - config.Fee.Flat), false
+	}
+	return h.sellShares(config, soldShares, price), 0, true
+}
+
+// sellAll sells every share, it returns what we get after tax and fee
+func (h *holding) sellAll(config *Config, price float64) (sale, float64) {
+	net := h.netOf(config, h.shares, price) - config.Fee.Flat
+	return h.sellShares(config, h.shares, price), net
+}
+
+func (h *holding) sellShares(config *Config, shares, price float64) sale {
+	costBasis, gain := h.costBasis, shares*math.Max(price-h.costBasis, 0)
+	if config.FIFO {
+		costBasis, gain = h.removeLots(shares, price)
+	}
+	h.shares -= shares
+	return sale{
+		name:      h.name,
+		shares:    shares,
+		price:     price,
+		costBasis: costBasis,
+		remained:  h.shares,
+		tax:       gain * config.TaxRate,
+		fee:       config.Fee.Of(shares * price),
+	}
+}
+
+// remove takes shares out without selling them like rebalancing, the oldest lots go first with Config.FIFO
+func (h *holding) remove(config *Config, shares float64) {
+	if config.FIFO {
+		h.removeLots(shares, 0)
+	}
+	h.shares -= shares
+}
+
+// removeLots removes shares from the oldest lots, it returns the average cost of them and their gains at price.
+// Lots are copied instead of changed in place, so a copy of the portfolio before a failed withdrawal keeps them.
+func (h *holding) removeLots(shares, price float64) (float64, float64) {
+	lots := make([]lot, 0, len(h.lots))
+	cost, gain, left := float64(0), float64(0), shares
+	for _, held := range h.lots {
+		sold := math.Min(left, held.shares)
+		left -= sold
+		cost += sold * held.price
+		gain += sold * math.Max(price-held.price, 0)
+		if held.shares > sold {
+			lots = append(lots, lot{held.shares - sold, held.price})
+		}
+	}
+	h.lots = lots
+	if shares == 0 {
+		return 0, 0
+	}
+	return cost / shares, gain
+}
+
+// portfolio is stocks of datePrices, bonds of Config.Bonds and cash earning Config.CashReturn
+type portfolio struct {
+	stocks holding
+	bonds  holding
+	// cash on cashDate, interest accrues after that
+	cash     float64
+	cashDate time.Time
+	// dividends of stocks are reinvested until dividendDate
+	dividendDate time.Time
+}
+
+// newPortfolio buys stocks and bonds with capital on the day by the target allocation
+func (c *Config) newPortfolio(datePrice *DatePrice) *portfolio {
+	p := &portfolio{
+		stocks: holding{name: "shares"},
+		bonds:  holding{name: "bond shares"},
+	}
+	p.cash, p.cashDate = math.Min(float64(c.Capital)*c.CashWeight+c.reserve(0, 1), float64(c.Capital)), datePrice.Date
+	p.dividendDate = datePrice.Date
+	invested := float64(c.Capital) - p.cash
+	p.stocks.buy(c, invested*c.stockWeight(), c.buyPrice(datePrice))
+	if c.Bonds != nil {
+		p.bonds.buy(c, invested*(1-c.StockWeight), c.buyPrice(c.bondDay(datePrice)))
+	}
+	return p
+}
+
+// contribute invests amount in portfolio on the day by the target allocation
+func (c *Config) contribute(p *portfolio, datePrice *DatePrice, amount float64) {
+	if c.CashWeight > 0 {
+		p.cash, p.cashDate = c.cashValue(p, datePrice.Date)+amount*c.CashWeight, datePrice.Date
+	}
+	invested := amount * (1 - c.CashWeight)
+	p.stocks.buy(c, invested*c.stockWeight(), c.price(datePrice))
+	if c.Bonds != nil {
+		p.bonds.buy(c, invested*(1-c.StockWeight), c.bondPrice(datePrice))
+	}
+}
+
+// dividendGrowth is how much stocks grow by reinvesting dividends of Config.DividendYield per year since the last reinvestment
+func (c *Config) dividendGrowth(p *portfolio, date time.Time) float64 {
+	if c.DividendYield == 0 || !date.After(p.dividendDate) {
+		return 1
+	}
+	return math.Pow(1+c.DividendYield, yearsBetween(p.dividendDate, date))
+}
+
+// reinvestDividends buys stocks with dividends accrued since the last reinvestment,
+// it approximates total return of price only data, tax of dividends is ignored
+func (c *Config) reinvestDividends(p *portfolio, datePrice *DatePrice) {
+	growth := c.dividendGrowth(p, datePrice.Date)
+	if growth == 1 {
+		return
+	}
+	price := c.price(datePrice)
+	p.stocks.buy(c, p.stocks.value(price)*(growth-1), price)
+	p.dividendDate = datePrice.Date
+}
+
+// capital is the value of portfolio on the day
+func (c *Config) capital(p *portfolio, datePrice *DatePrice) float64 {
+	value := c.investedCapital(p, datePrice)
+	if p.cash > 0 {
+		value += c.cashValue(p, datePrice.Date)
+	}
+	return value
+}
+
+// investedCapital is the value of stocks and bonds on the day
+func (c *Config) investedCapital(p *portfolio, datePrice *DatePrice) float64 {
+	// dividends not reinvested yet are worth as much as stocks they buy
+	value := p.stocks.value(c.price(datePrice)) * c.dividendGrowth(p, datePrice.Date)
+	if c.Bonds != nil {
+		value += p.bonds.value(c.bondPrice(datePrice))
+	}
+	return value
+}
+
+// cashValue is cash with interest accrued to the day
+func (c *Config) cashValue(p *portfolio, date time.Time) float64 {
+	return p.cash * math.Pow(1+c.CashReturn, yearsBetween(p.cashDate, date))
+}
+
+// bondPrice is the price of bonds on the closest day of datePrice
+func (c *Config) bondPrice(datePrice *DatePrice) float64 {
+	return c.price(c.bondDay(datePrice))
+}
+
+// bondDay is the closest day of datePrice in bonds
+func (c *Config) bondDay(datePrice *DatePrice) *DatePrice {
+	index, found := findClosestDay(datePrice.Date, c.Bonds)
+	if !found {
+		index = len(c.Bonds) - 1
+	}
+	return &c.Bonds[index]
+}
+
+// stockWeight is the allocation of stocks, it's 1 without bonds
+func (c *Config) stockWeight() float64 {
+	if c.Bonds == nil {
+		return 1
+	}
+	return c.StockWeight
+}
+
+// rebalance trades between stocks and bonds on the day to restore target allocation,
+// rebalancing is assumed to be free of tax and fee
+func (c *Config) rebalance(p *portfolio, datePrice *DatePrice) {
+	if c.Bonds == nil {
+		return
+	}
+	stockPrice, bondPrice := c.price(datePrice), c.bondPrice(datePrice)
+	targetStocks := c.investedCapital(p, datePrice) * c.StockWeight
+	if diff := targetStocks - p.stocks.value(stockPrice); diff > 0 {
+		sold := math.Min(c.shares(diff/bondPrice), p.bonds.shares)
+		p.bonds.remove(c, sold)
+		p.stocks.buy(c, sold*bondPrice, stockPrice)
+	} else {
+		sold := math.Min(c.shares(-diff/stockPrice), p.stocks.shares)
+		p.stocks.remove(c, sold)
+		p.bonds.buy(c, sold*stockPrice, bondPrice)
+	}
+}
+
+// withdrawal is cash drawn and shares sold to get money
+type withdrawal struct {
+	cash  float64
+	sales []sale
+}
+
+// withdraw draws cash first, then sells stocks and bonds on the day to get the rest after tax and fee,
+// either in proportion to their value or bonds first.
+// It returns the shortfall if the portfolio can't fund amount, nothing is withdrawn then.
+func (c *Config) withdraw(p *portfolio, datePrice *DatePrice, amount float64) (withdrawal, float64, bool) {
+	before := *p
+	drawn := float64(0)
+	if p.cash > 0 {
+		cash := c.cashValue(p, datePrice.Date)
+		drawn = math.Min(cash, amount)
+		p.cash, p.cashDate = cash-drawn, datePrice.Date
+		amount -= drawn
+	}
+	if amount <= 0 {
+		return withdrawal{cash: drawn}, 0, true
+	}
+
+	sales, shortfall, ok := c.sell(p, datePrice, amount)
+	if !ok {
+		*p = before
+		return withdrawal{}, shortfall, false
+	}
+	return withdrawal{
+		cash:  drawn,
+		sales: sales,
+	}, 0, true
+}
+
+// sell sells stocks and bonds on the day to get amount after tax and fee.
+// It returns the shortfall if they can't fund amount, p may be changed then.
+func (c *Config) sell(p *portfolio, datePrice *DatePrice, amount float64) ([]sale, float64, bool) {
+	stockPrice := c.price(datePrice)
+	if c.Bonds == nil {
+		stockSale, shortfall, ok := p.stocks.sell(c, amount, stockPrice)
+		return []sale{stockSale}, shortfall, ok
+	}
+
+	bondPrice := c.bondPrice(datePrice)
+	bondAmount := amount
+	if capital := c.investedCapital(p, datePrice); !c.BondsFirst && capital > 0 {
+		bondAmount = amount * p.bonds.value(bondPrice) / capital
+	}
+
+	sales := []sale{}
+	if all := p.bonds.netOf(c, p.bonds.shares, bondPrice) - c.Fee.Flat; all <= bondAmount {
+		// bonds can't fund their part, sell them all and the rest from stocks
+		bondAmount = 0
+		if all > 0 {
+			bondSale, net := p.bonds.sellAll(c, bondPrice)
+			sales = append(sales, bondSale)
+			bondAmount = net
+		}
+	} else if bondAmount > 0 {
+		bondSale, _, _ := p.bonds.sell(c, bondAmount, bondPrice)
+		sales = append(sales, bondSale)
+	}
+	if stockAmount := amount - bondAmount; stockAmount > 0 {
+		stockSale, shortfall, ok := p.stocks.sell(c, stockAmount, stockPrice)
+		if !ok {
+			return nil, shortfall, false
+		}
+		sales = append(sales, stockSale)
+	}
+	return sales, 0, true
+}
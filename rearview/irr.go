@@ -0,0 +1,41 @@
+package rearview
+
+import (
+	"math"
+	"time"
+)
+
+// cashFlow is money invested on a day if it's negative, or received if it's positive
+type cashFlow struct {
+	date   time.Time
+	amount float64
+}
+
+// irr is the annual rate which discounts flows to a net present value of 0, found by bisection between -99% and 1000%,
+// it's NaN if there's no such rate in between, like flows which are never received, or flows are all on one day
+func irr(flows []cashFlow) float64 {
+	if len(flows) == 0 || !flows[len(flows)-1].date.After(flows[0].date) {
+		return math.NaN()
+	}
+	npv := func(rate float64) float64 {
+		value := float64(0)
+		for _, flow := range flows {
+			value += flow.amount / math.Pow(1+rate, yearsBetween(flows[0].date, flow.date))
+		}
+		return value
+	}
+	low, high := -0.99, 10.0
+	lowValue := npv(low)
+	if lowValue*npv(high) > 0 {
+		return math.NaN()
+	}
+	for i := 0; i < 100 && high-low > 1e-9; i++ {
+		middle := (low + high) / 2
+		if value := npv(middle); value*lowValue > 0 {
+			low, lowValue = middle, value
+		} else {
+			high = middle
+		}
+	}
+	return (low + high) / 2
+}
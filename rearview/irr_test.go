@@ -0,0 +1,50 @@
+package rearview
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestIRR(t *testing.T) {
+	start, end := date("2000-01-01"), date("2002-01-01")
+	flows := []cashFlow{{start, -1000}, {end, 1210}}
+	// 21% in about 2 years
+	want := math.Pow(1.21, 1/yearsBetween(start, end)) - 1
+	if rate := irr(flows); math.Abs(rate-want) > 1e-6 {
+		t.Errorf("irr = %f, want %f", rate, want)
+	}
+
+	for _, test := range []struct {
+		name  string
+		flows []cashFlow
+	}{
+		{name: "no flows"},
+		{name: "never received", flows: []cashFlow{{start, -1000}, {end, -100}}},
+		{name: "one day", flows: []cashFlow{{start, -1000}, {start, 1210}}},
+	} {
+		if rate := irr(test.flows); !math.IsNaN(rate) {
+			t.Errorf("%s: irr = %f, want NaN", test.name, rate)
+		}
+	}
+}
+
+func TestCheckStartsIRR(t *testing.T) {
+	datePrices := growingSeries("2000-01-03", 3)
+	config := Config{Capital: 1000, Run: 2, YearPerRun: 1, InflationRate: 1, CostPerYear: 50, IRR: true}
+	check := func(start time.Time) StrategyResult {
+		result, err := CheckStarts(context.Background(), &config, datePrices, []time.Time{start}, NopLogger{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	if result := check(datePrices[0].Date); result.IRR == nil || result.IRR.Median <= 0 {
+		t.Errorf("irr of a successful period is %+v, want a positive rate", result.IRR)
+	}
+	// an N/A period has no rate, which is not a rate of 0%
+	if result := check(date("2010-01-04")); result.IRR != nil {
+		t.Errorf("irr without a rate is %+v, want nil", *result.IRR)
+	}
+}
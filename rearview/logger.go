@@ -0,0 +1,106 @@
+package rearview
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Level is how much a Logger writes
+type Level int
+
+const (
+	// LevelInfo writes results only
+	LevelInfo Level = iota
+	// LevelTrace writes traces of the simulation too
+	LevelTrace
+	// LevelDebug writes details of trades too, like cost basis and rebalancing
+	LevelDebug
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelInfo:
+		return "info"
+	case LevelTrace:
+		return "trace"
+	case LevelDebug:
+		return "debug"
+	default:
+		return fmt.Sprintf("unknown level %d", int(l))
+	}
+}
+
+// ParseLevel parses info, trace or debug
+func ParseLevel(name string) (Level, error) {
+	for l := LevelInfo; l <= LevelDebug; l++ {
+		if l.String() == name {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %s, expect info, trace or debug", name)
+}
+
+// Logger prints results, traces of the simulation and debug details by level
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Tracef(format string, v ...interface{})
+	Printf(format string, v ...interface{})
+}
+
+// logger writes to writer and traces to traceWriter, it's safe for concurrent use, each write is serialized
+type logger struct {
+	writer      io.Writer
+	traceWriter io.Writer
+	level       Level
+	mu          sync.Mutex
+}
+
+func (l *logger) Debugf(format string, v ...interface{}) {
+	if l.level >= LevelDebug {
+		l.fprintf(l.traceWriter, format, v...)
+	}
+}
+
+func (l *logger) Tracef(format string, v ...interface{}) {
+	if l.level >= LevelTrace {
+		l.fprintf(l.traceWriter, format, v...)
+	}
+}
+
+func (l *logger) Printf(format string, v ...interface{}) {
+	l.fprintf(l.writer, format, v...)
+}
+
+func (l *logger) fprintf(writer io.Writer, format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(writer, format, v...)
+}
+
+// NewLogger returns a Logger writing to writer, traces are written only if verbose
+func NewLogger(writer io.Writer, verbose bool) Logger {
+	level := LevelInfo
+	if verbose {
+		level = LevelTrace
+	}
+	return NewTraceLogger(writer, writer, level)
+}
+
+// NewTraceLogger returns a Logger writing results to writer, and traces and debug details up to level to traceWriter
+func NewTraceLogger(writer, traceWriter io.Writer, level Level) Logger {
+	return &logger{
+		writer:      writer,
+		traceWriter: traceWriter,
+		level:       level,
+	}
+}
+
+// NopLogger writes nothing, for embedding the backtester in a program which prints results its own way
+type NopLogger struct{}
+
+func (NopLogger) Debugf(format string, v ...interface{}) {}
+
+func (NopLogger) Tracef(format string, v ...interface{}) {}
+
+func (NopLogger) Printf(format string, v ...interface{}) {}
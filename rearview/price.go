@@ -0,0 +1,310 @@
+// Package rearview backtests withdrawal strategies against historical prices.
+package rearview
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// DatePrice is the prices of a trading day
+type DatePrice struct {
+	Date       time.Time
+	OpenPrice  float64
+	HighPrice  float64
+	LowPrice   float64
+	ClosePrice float64
+	AdjClose   float64
+}
+
+// DateCPI is the consumer price index of a day
+type DateCPI struct {
+	Date time.Time
+	CPI  float64
+}
+
+// IsPriceField reports whether field names a price of DatePrice,
+// one of open, high, low, close or adjclose
+func IsPriceField(field string) bool {
+	switch field {
+	case "open", "high", "low", "close", "adjclose":
+		return true
+	}
+	return false
+}
+
+// ValidateDateOrder makes sure dates are strictly ascending, findClosestDay relies on it
+func ValidateDateOrder(datePrices []DatePrice) error {
+	for i := 1; i < len(datePrices); i++ {
+		if !datePrices[i].Date.After(datePrices[i-1].Date) {
+			return fmt.Errorf("day %d of the data: date %s is not after %s, dates must be sorted ascending without duplicates",
+				i+1,
+				toyyyymmdd(datePrices[i].Date),
+				toyyyymmdd(datePrices[i-1].Date),
+			)
+		}
+	}
+	return nil
+}
+
+// Gap is days without data between two days with data
+type Gap struct {
+	From time.Time
+	To   time.Time
+	Days int
+}
+
+// FindGaps finds gaps of more than days calendar days between consecutive days of sorted datePrices
+func FindGaps(datePrices []DatePrice, days int) []Gap {
+	gaps := []Gap{}
+	for i := 1; i < len(datePrices); i++ {
+		from, to := datePrices[i-1].Date, datePrices[i].Date
+		if gap := daysBetween(from, to); gap > days {
+			gaps = append(gaps, Gap{From: from, To: to, Days: gap})
+		}
+	}
+	return gaps
+}
+
+// SortByDate sorts datePrices by date ascending
+func SortByDate(datePrices []DatePrice) {
+	sort.SliceStable(datePrices, func(i, j int) bool {
+		return datePrices[i].Date.Before(datePrices[j].Date)
+	})
+}
+
+// MergeSeries concatenates series into one sorted by date, a date in more than one series takes prices of the last one,
+// dates of different prices in them are returned as conflicts
+func MergeSeries(series ...[]DatePrice) ([]DatePrice, []time.Time) {
+	all := []DatePrice{}
+	for _, datePrices := range series {
+		all = append(all, datePrices...)
+	}
+	SortByDate(all)
+
+	merged, conflicts := make([]DatePrice, 0, len(all)), []time.Time{}
+	for _, datePrice := range all {
+		if n := len(merged); n > 0 && merged[n-1].Date.Equal(datePrice.Date) {
+			// stable sort keeps the order of series, so the last one wins
+			if merged[n-1] != datePrice && (len(conflicts) == 0 || !conflicts[len(conflicts)-1].Equal(datePrice.Date)) {
+				conflicts = append(conflicts, datePrice.Date)
+			}
+			merged[n-1] = datePrice
+			continue
+		}
+		merged = append(merged, datePrice)
+	}
+	return merged, conflicts
+}
+
+// BlendSeries blends sorted series into one index by weights, like 70% of S&P 500 and 30% of an international index.
+// The index starts at 100 on the first day all series have and ends on the last day all of them have,
+// days are those of the first series, the others take prices of their closest day.
+// It's rebalanced to weights every day, so it's the return of a fixed allocation, each price field is blended on its own.
+func BlendSeries(series [][]DatePrice, weights []float64) ([]DatePrice, error) {
+	if len(series) == 0 || len(series) != len(weights) {
+		return nil, fmt.Errorf("%d series with %d weights", len(series), len(weights))
+	}
+	total := float64(0)
+	for i, weight := range weights {
+		if weight <= 0 {
+			return nil, fmt.Errorf("weight %g of series %d is not positive", weight, i+1)
+		}
+		if len(series[i]) == 0 {
+			return nil, fmt.Errorf("series %d has no data", i+1)
+		}
+		total += weight
+	}
+
+	start, end := series[0][0].Date, series[0][len(series[0])-1].Date
+	for _, datePrices := range series[1:] {
+		if first := datePrices[0].Date; first.After(start) {
+			start = first
+		}
+		if last := datePrices[len(datePrices)-1].Date; last.Before(end) {
+			end = last
+		}
+	}
+	if start.After(end) {
+		return nil, fmt.Errorf("series have no days in common, the latest first day %s is after the earliest last day %s", toyyyymmdd(start), toyyyymmdd(end))
+	}
+
+	blended := []DatePrice{}
+	index := [5]float64{100, 100, 100, 100, 100}
+	previous := make([]int, len(series))
+	for _, day := range series[0] {
+		if day.Date.Before(start) || day.Date.After(end) {
+			continue
+		}
+		growth := [5]float64{}
+		for i, datePrices := range series {
+			// found, the day is within all series
+			closest, _ := findClosestDay(day.Date, datePrices)
+			if len(blended) == 0 {
+				previous[i] = closest
+			}
+			now, before := priceFields(&datePrices[closest]), priceFields(&datePrices[previous[i]])
+			for field := range growth {
+				growth[field] += weights[i] / total * now[field] / before[field]
+			}
+			previous[i] = closest
+		}
+		for field := range index {
+			index[field] *= growth[field]
+		}
+		blended = append(blended, DatePrice{
+			Date:       day.Date,
+			OpenPrice:  index[0],
+			HighPrice:  index[1],
+			LowPrice:   index[2],
+			ClosePrice: index[3],
+			AdjClose:   index[4],
+		})
+	}
+	return blended, nil
+}
+
+// priceFields is open, high, low, close and adjclose of datePrice
+func priceFields(datePrice *DatePrice) [5]float64 {
+	return [5]float64{datePrice.OpenPrice, datePrice.HighPrice, datePrice.LowPrice, datePrice.ClosePrice, datePrice.AdjClose}
+}
+
+// SliceDateRange returns datePrices from the closest day of start to the closest day of end,
+// zero start or end means no bound on that side
+func SliceDateRange(datePrices []DatePrice, start, end time.Time) []DatePrice {
+	startIndex, endIndex := 0, len(datePrices)
+	if !start.IsZero() {
+		index, found := findClosestDay(start, datePrices)
+		if !found {
+			return nil
+		}
+		startIndex = index
+	}
+	if !end.IsZero() {
+		if index, found := findClosestDay(end, datePrices); found {
+			endIndex = index + 1
+		}
+	}
+	if startIndex >= endIndex {
+		return nil
+	}
+	return datePrices[startIndex:endIndex]
+}
+
+// DownsampleWeekly keeps the last day of each ISO week of sorted datePrices.
+// It's for quick exploration, results differ from daily data as there are fewer start days and days to trade on.
+func DownsampleWeekly(datePrices []DatePrice) []DatePrice {
+	return downsample(datePrices, func(date time.Time) int {
+		year, week := date.ISOWeek()
+		return year*100 + week
+	})
+}
+
+// DownsampleMonthly keeps the last day of each month of sorted datePrices, see DownsampleWeekly
+func DownsampleMonthly(datePrices []DatePrice) []DatePrice {
+	return downsample(datePrices, func(date time.Time) int {
+		return date.Year()*100 + int(date.Month())
+	})
+}
+
+// downsample keeps the last day of each group of consecutive days with the same key
+func downsample(datePrices []DatePrice, key func(date time.Time) int) []DatePrice {
+	kept := []DatePrice{}
+	for i := range datePrices {
+		if i+1 == len(datePrices) || key(datePrices[i].Date) != key(datePrices[i+1].Date) {
+			kept = append(kept, datePrices[i])
+		}
+	}
+	return kept
+}
+
+// GenerateSeries generates days of synthetic prices from start, a geometric random walk of a step per weekday
+// starting at 100, which grows annualReturn per year on median with annualVol of volatility per year.
+// The same seed always generates the same series, it's for testing programs using the package.
+func GenerateSeries(start time.Time, days int, annualReturn, annualVol float64, seed int64) []DatePrice {
+	random := rand.New(rand.NewSource(seed))
+	// weekdays of a year
+	dt := 7.0 / 5 / 365.25
+	drift, step := math.Log(1+annualReturn)*dt, annualVol*math.Sqrt(dt)
+	datePrices := make([]DatePrice, 0, days)
+	date, price := start, 100.0
+	for i := 0; i < days; i++ {
+		open := price
+		if i > 0 {
+			price *= math.Exp(drift + step*random.NormFloat64())
+			date = date.AddDate(0, 0, 1)
+			for date.Weekday() == time.Saturday || date.Weekday() == time.Sunday {
+				date = date.AddDate(0, 0, 1)
+			}
+		}
+		datePrices = append(datePrices, DatePrice{
+			Date:       date,
+			OpenPrice:  open,
+			HighPrice:  math.Max(open, price),
+			LowPrice:   math.Min(open, price),
+			ClosePrice: price,
+			AdjClose:   price,
+		})
+	}
+	return datePrices
+}
+
+// findClosestDay returns the index of the date nearest to day,
+// the earlier date wins if both neighbors are equally near.
+// A day after the last available date is not found.
+// Dates are expected unique, ValidateDateOrder rejects duplicates and MergeSeries keeps one of them,
+// for a series which still has duplicate dates the earliest index of them wins.
+func findClosestDay(day time.Time, inDatePrices []DatePrice) (int, bool) {
+	return findClosestDate(day, len(inDatePrices), func(i int) time.Time {
+		return inDatePrices[i].Date
+	})
+}
+
+// findClosestDate is findClosestDay for any ascending series of n dates
+func findClosestDate(day time.Time, n int, dateAt func(i int) time.Time) (int, bool) {
+	// the first index not before day, so it's the earliest of duplicates,
+	// and the one before it is strictly before day, but the latest of its duplicates
+	index := sort.Search(n, func(i int) bool {
+		date := dateAt(i)
+		return date.After(day) || date.Equal(day)
+	})
+	if index == n {
+		return -1, false
+	}
+	if index > 0 && day.Sub(dateAt(index-1)) <= dateAt(index).Sub(day) {
+		index--
+		// back to the earliest of duplicates of the day before
+		for index > 0 && dateAt(index-1).Equal(dateAt(index)) {
+			index--
+		}
+	}
+	return index, true
+}
+
+// findClosestCPI is findClosestDay for cpi, a day before the first cpi is not found either,
+// like a day after the last one, inflation of days cpi doesn't cover is unknown instead of none
+func findClosestCPI(day time.Time, cpi []DateCPI) (int, bool) {
+	if len(cpi) == 0 || day.Before(cpi[0].Date) {
+		return -1, false
+	}
+	return findClosestDate(day, len(cpi), func(i int) time.Time {
+		return cpi[i].Date
+	})
+}
+
+// anniversary is the same day years after day, every run boundary of a period is an anniversary of its first day,
+// so boundaries don't drift run by run. Feb 29 has its anniversary on Feb 28 in a year which is not a leap year,
+// instead of Mar 1 as AddDate.
+func anniversary(day time.Time, years int) time.Time {
+	date := day.AddDate(years, 0, 0)
+	if day.Month() == time.February && day.Day() == 29 && date.Month() == time.March {
+		date = date.AddDate(0, 0, -1)
+	}
+	return date
+}
+
+func toyyyymmdd(date time.Time) string {
+	return date.Format("2006-01-02")
+}
@@ -0,0 +1,155 @@
+package rearview
+
+import (
+	"testing"
+	"time"
+)
+
+func date(value string) time.Time {
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		panic(err)
+	}
+	return day
+}
+
+func days(values ...string) []DatePrice {
+	datePrices := []DatePrice{}
+	for _, value := range values {
+		datePrices = append(datePrices, DatePrice{Date: date(value)})
+	}
+	return datePrices
+}
+
+func TestFindClosestDay(t *testing.T) {
+	// a friday and the next monday
+	datePrices := days("2021-01-01", "2021-01-04", "2021-01-08")
+	tests := []struct {
+		day   string
+		index int
+		found bool
+	}{
+		{"2021-01-01", 0, true},
+		// saturday, nearer the friday before
+		{"2021-01-02", 0, true},
+		// sunday, nearer the monday after
+		{"2021-01-03", 1, true},
+		// exactly between monday and friday, the earlier day wins
+		{"2021-01-06", 1, true},
+		{"2021-01-07", 2, true},
+		{"2021-01-08", 2, true},
+		{"2021-01-09", -1, false},
+		{"2000-01-01", 0, true},
+	}
+	for _, test := range tests {
+		index, found := findClosestDay(date(test.day), datePrices)
+		if index != test.index || found != test.found {
+			t.Errorf("findClosestDay(%s) = %d, %v, want %d, %v", test.day, index, found, test.index, test.found)
+		}
+	}
+	if index, found := findClosestDay(date("2021-01-01"), nil); index != -1 || found {
+		t.Errorf("findClosestDay of no days = %d, %v, want -1, false", index, found)
+	}
+}
+
+func TestFindClosestDayDuplicates(t *testing.T) {
+	datePrices := days("2021-01-01", "2021-01-03", "2021-01-03", "2021-01-10")
+	tests := []struct {
+		day   string
+		index int
+	}{
+		// rounding forward, or the day itself
+		{"2021-01-03", 1},
+		// rounding backward
+		{"2021-01-04", 1},
+		{"2021-01-09", 3},
+	}
+	for _, test := range tests {
+		if index, _ := findClosestDay(date(test.day), datePrices); index != test.index {
+			t.Errorf("findClosestDay(%s) = %d, want the earliest duplicate %d", test.day, index, test.index)
+		}
+	}
+}
+
+func TestFindRunEndsMatchesFindClosestDay(t *testing.T) {
+	datePrices := days("2020-01-01", "2020-06-01", "2021-01-01", "2021-01-04", "2021-01-04", "2021-06-01", "2022-01-01", "2022-01-02")
+	config := &Config{Run: 2, YearPerRun: 1}
+	ends := findRunEnds(config, datePrices)
+	for i := range datePrices {
+		for run := 0; run < config.Run; run++ {
+			want, found := findClosestDay(config.runEnd(datePrices[i].Date, run), datePrices[i:])
+			if !found {
+				want = -1
+			}
+			if got := ends[i*config.Run+run]; got != want {
+				t.Errorf("end of run %d from day %d = %d, findClosestDay = %d", run, i, got, want)
+			}
+		}
+	}
+}
+
+func TestAnniversary(t *testing.T) {
+	tests := []struct {
+		day   string
+		years int
+		want  string
+	}{
+		{"1972-02-29", 1, "1973-02-28"},
+		{"1972-02-29", 4, "1976-02-29"},
+		{"1972-02-28", 1, "1973-02-28"},
+		{"1972-03-01", 1, "1973-03-01"},
+	}
+	for _, test := range tests {
+		if got := anniversary(date(test.day), test.years); !got.Equal(date(test.want)) {
+			t.Errorf("anniversary(%s, %d) = %s, want %s", test.day, test.years, toyyyymmdd(got), test.want)
+		}
+	}
+}
+
+func TestGenerateSeries(t *testing.T) {
+	start := date("2021-01-01")
+	series := GenerateSeries(start, 100, 0.07, 0.15, 1)
+	if len(series) != 100 || !series[0].Date.Equal(start) || series[0].ClosePrice != 100 {
+		t.Fatalf("%d days from %s at %f, want 100 days from %s at 100", len(series), series[0].Date, series[0].ClosePrice, start)
+	}
+	if err := ValidateDateOrder(series); err != nil {
+		t.Error(err)
+	}
+	again := GenerateSeries(start, 100, 0.07, 0.15, 1)
+	for i := range series {
+		if series[i] != again[i] {
+			t.Fatalf("day %d = %+v, then %+v of the same seed", i, series[i], again[i])
+		}
+	}
+	other := GenerateSeries(start, 100, 0.07, 0.15, 2)
+	same := true
+	for i := range series {
+		same = same && series[i] == other[i]
+	}
+	if same {
+		t.Error("seeds 1 and 2 generate the same series")
+	}
+}
+
+func TestFindClosestCPI(t *testing.T) {
+	cpi := []DateCPI{{Date: date("1970-01-01"), CPI: 38.76}, {Date: date("1971-01-01"), CPI: 40.06}}
+	tests := []struct {
+		day   string
+		index int
+		found bool
+	}{
+		// cpi doesn't cover days before the first or after the last one
+		{"1969-12-31", -1, false},
+		{"1970-01-01", 0, true},
+		{"1970-03-01", 0, true},
+		{"1970-12-01", 1, true},
+		{"1971-01-01", 1, true},
+		{"1971-01-02", -1, false},
+	}
+	for _, test := range tests {
+		index, found := findClosestCPI(date(test.day), cpi)
+		if index != test.index || found != test.found {
+			t.Errorf("findClosestCPI(%s) = %d, %v, want %d, %v", test.day, index, found, test.index, test.found)
+		}
+	}
+}
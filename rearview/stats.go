@@ -0,0 +1,78 @@
+package rearview
+
+import (
+	"math"
+	"time"
+)
+
+// SeriesStats describes a price series regardless of any strategy
+type SeriesStats struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// CAGR is the annual growth rate from the first day to the last day
+	CAGR float64 `json:"cagr"`
+	// MaxDrawdown is the worst fall from a peak, 0.5 is halved
+	MaxDrawdown float64   `json:"maxDrawdown"`
+	PeakDate    time.Time `json:"peakDate"`
+	TroughDate  time.Time `json:"troughDate"`
+	// LongestRecovery is the longest years from a peak until price is back to it,
+	// it's not Recovered if the price is still below the peak at the end of the series
+	LongestRecovery  float64   `json:"longestRecovery"`
+	RecoveryPeakDate time.Time `json:"recoveryPeakDate"`
+	RecoveryDate     time.Time `json:"recoveryDate"`
+	Recovered        bool      `json:"recovered"`
+}
+
+// Stats computes SeriesStats of the price config.PriceField of datePrices
+func Stats(config *Config, datePrices []DatePrice) SeriesStats {
+	stats := SeriesStats{}
+	if len(datePrices) == 0 {
+		return stats
+	}
+	first, last := &datePrices[0], &datePrices[len(datePrices)-1]
+	stats.Start, stats.End = first.Date, last.Date
+	if years := yearsBetween(first.Date, last.Date); years > 0 {
+		stats.CAGR = math.Pow(config.price(last)/config.price(first), 1/years) - 1
+	}
+
+	stats.Recovered = true
+	peak, dipped := first, false
+	for i := range datePrices {
+		datePrice := &datePrices[i]
+		price := config.price(datePrice)
+		if price >= config.price(peak) {
+			// back to the peak or a new one
+			if recovery := yearsBetween(peak.Date, datePrice.Date); dipped && recovery > stats.LongestRecovery {
+				stats.LongestRecovery, stats.RecoveryPeakDate, stats.RecoveryDate = recovery, peak.Date, datePrice.Date
+			}
+			peak, dipped = datePrice, false
+			continue
+		}
+		dipped = true
+		if drawdown := 1 - price/config.price(peak); drawdown > stats.MaxDrawdown {
+			stats.MaxDrawdown, stats.PeakDate, stats.TroughDate = drawdown, peak.Date, datePrice.Date
+		}
+	}
+	// still under water at the end
+	if underWater := yearsBetween(peak.Date, last.Date); dipped && underWater > stats.LongestRecovery {
+		stats.LongestRecovery, stats.RecoveryPeakDate, stats.RecoveryDate = underWater, peak.Date, last.Date
+		stats.Recovered = false
+	}
+	return stats
+}
+
+// DrawdownWithin is the worst fall of prices within years after the closest day of start, from a peak of close to a later low,
+// like 0.3 for a crash of 30% right after retiring on start, it's 0 if start is after the last day
+func DrawdownWithin(datePrices []DatePrice, start time.Time, years int) float64 {
+	index, found := findClosestDay(start, datePrices)
+	if !found {
+		return 0
+	}
+	end := anniversary(datePrices[index].Date, years)
+	peak, worst := datePrices[index].ClosePrice, float64(0)
+	for i := index; i < len(datePrices) && !datePrices[i].Date.After(end); i++ {
+		worst = math.Max(worst, 1-datePrices[i].LowPrice/peak)
+		peak = math.Max(peak, datePrices[i].ClosePrice)
+	}
+	return worst
+}
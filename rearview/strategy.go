@@ -0,0 +1,207 @@
+package rearview
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RunState is what a strategy knows about one run of a period
+type RunState struct {
+	Run        int
+	StartIndex int
+	EndIndex   int
+	// InflationRate is inflation from the first day of the period to the end of this run
+	InflationRate float64
+	// Fraction of a whole run this run lasts, it's less than 1 only for the last run with Config.Partial,
+	// which ends with data, its cost of living and withdrawal are in proportion
+	Fraction   float64
+	HeldShares float64
+	BondShares float64
+	// Withdrawn is the withdrawal of the previous run, 0 in the first run
+	Withdrawn float64
+	// PrevCapital is capital right after the withdrawal of the previous run, 0 in the first run
+	PrevCapital float64
+	// PrevInflationRate is InflationRate of the previous run, 1 in the first run, or inflation of Config.AccumulateYears
+	PrevInflationRate float64
+	// Principal is the initial capital, or capital when Config.AccumulateYears end in dollars of the first day
+	Principal float64
+
+	config     *Config
+	datePrices []DatePrice
+	portfolio  *portfolio
+}
+
+// Capital is the value of stocks and bonds held on the day of index
+func (s *RunState) Capital(index int) float64 {
+	return s.config.capital(s.portfolio, &s.datePrices[index])
+}
+
+// costOfLiving is the cost of living of the run in proportion to Fraction
+func (s *RunState) costOfLiving() float64 {
+	return s.config.costOfLiving(s.Run, s.InflationRate) * s.Fraction
+}
+
+// years are years of the run
+func (s *RunState) years() float64 {
+	return float64(s.config.YearPerRun) * s.Fraction
+}
+
+// Strategy decides when and how much to withdraw in each run
+type Strategy interface {
+	// Withdraw returns index of the day to sell and the cost of living to get on that day,
+	// or an error explaining why the strategy fails in this run with index of the day it falls short
+	Withdraw(config *Config, datePrices []DatePrice, state *RunState, logger Logger) (int, float64, error)
+}
+
+// FixedStrategy withdraws the inflation adjusted cost of living of the run
+// on the first day capital reaches the inflation adjusted initial capital plus the cost of living.
+//
+// The target always inflates the initial capital, not what is left after earlier runs,
+// since the idea checked is living on the gains while the principal keeps its real value.
+// So a run after a sale has to grow back what was sold, e.g. 333333 initial capital,
+// after 10 years of 1.6% inflation, is a target of 333333 x 1.1720 + the cost of living.
+// A period which has withdrawn more than it has grown fails, even if it could still pay the bills.
+// Margin multiplies the target like 1.1 to sell only once capital is 10% above it, 0 is no margin,
+// the withdrawal is still the cost of living.
+type FixedStrategy struct {
+	Margin float64
+}
+
+func (s FixedStrategy) Withdraw(config *Config, datePrices []DatePrice, state *RunState, logger Logger) (int, float64, error) {
+	// compute captial after this run and cost of live with inflation considered
+	// add these two then we have target capital in this run
+	inflationCapital := state.Principal * state.InflationRate
+	costOfLiving := state.costOfLiving()
+	targetCapital := inflationCapital + costOfLiving
+	if s.Margin > 0 {
+		targetCapital *= s.Margin
+	}
+	logger.Tracef("%s to %s, target capital %d, prepared cost of living %d\n",
+		toyyyymmdd(datePrices[state.StartIndex].Date),
+		toyyyymmdd(datePrices[state.EndIndex].Date),
+		int(config.traced(targetCapital, state.InflationRate)),
+		int(config.traced(costOfLiving, state.InflationRate)),
+	)
+	margin := ""
+	if s.Margin > 0 {
+		margin = fmt.Sprintf(", all x margin %.2f", s.Margin)
+	}
+	// in dollars of the first day the initial capital keeps its value
+	inflation := state.InflationRate
+	if config.RealTrace {
+		inflation = 1
+	}
+	logger.Tracef("target capital is initial capital %d x inflation %.4f + cost of living %d%s, capital now %d\n",
+		int64(state.Principal),
+		inflation,
+		int(config.traced(costOfLiving, state.InflationRate)),
+		margin,
+		int(config.traced(state.Capital(state.StartIndex), state.InflationRate)),
+	)
+
+	for currIndex := state.StartIndex; currIndex < state.EndIndex; currIndex++ {
+		// find one day in this run satisfy our target captial
+		if state.Capital(currIndex) >= targetCapital {
+			return currIndex, costOfLiving, nil
+		}
+	}
+	// capital is short of target since the first day of the run
+	return state.StartIndex, 0, errors.New("capital never reaches target capital")
+}
+
+// ConstantStrategy withdraws the inflation adjusted cost of living of the run on the first day of each run like the Trinity study,
+// it fails only once the portfolio can't fund a withdrawal, so it's the strategy of a safe withdrawal rate
+type ConstantStrategy struct{}
+
+func (ConstantStrategy) Withdraw(config *Config, datePrices []DatePrice, state *RunState, logger Logger) (int, float64, error) {
+	costOfLiving := state.costOfLiving()
+	logger.Tracef("%s to %s, capital %d, withdraw cost of living %d\n",
+		toyyyymmdd(datePrices[state.StartIndex].Date),
+		toyyyymmdd(datePrices[state.EndIndex].Date),
+		int(config.traced(state.Capital(state.StartIndex), state.InflationRate)),
+		int(config.traced(costOfLiving, state.InflationRate)),
+	)
+	return state.StartIndex, costOfLiving, nil
+}
+
+// PercentStrategy withdraws Rate of capital per year on the first day of each run,
+// it fails once the withdrawal is less than the inflation adjusted cost of living.
+// Floor and Ceiling are inflation adjusted bounds per year of the withdrawal in dollars of the start day, 0 is no bound.
+// With Floor the withdrawal is raised to it instead of failing, and the period fails only if the portfolio can't fund it.
+type PercentStrategy struct {
+	Rate    float64
+	Floor   int
+	Ceiling int
+}
+
+func (s PercentStrategy) Withdraw(config *Config, datePrices []DatePrice, state *RunState, logger Logger) (int, float64, error) {
+	capital := state.Capital(state.StartIndex)
+	withdrawal := capital * s.Rate * state.years()
+	floor := state.costOfLiving()
+	rule := "percent"
+	if s.Floor > 0 {
+		floor = float64(s.Floor) * state.years() * state.InflationRate
+		if withdrawal < floor {
+			withdrawal, rule = floor, "raised to floor"
+		}
+	}
+	if ceiling := float64(s.Ceiling) * state.years() * state.InflationRate; s.Ceiling > 0 && withdrawal > ceiling {
+		withdrawal, rule = ceiling, "cut to ceiling"
+	}
+	logger.Tracef("%s to %s, capital %d, withdraw %d (%s), least withdrawal %d\n",
+		toyyyymmdd(datePrices[state.StartIndex].Date),
+		toyyyymmdd(datePrices[state.EndIndex].Date),
+		int(config.traced(capital, state.InflationRate)),
+		int(config.traced(withdrawal, state.InflationRate)),
+		rule,
+		int(config.traced(floor, state.InflationRate)),
+	)
+
+	if withdrawal < floor {
+		return state.StartIndex, 0, errors.New("withdrawal is less than cost of living")
+	}
+	return state.StartIndex, withdrawal, nil
+}
+
+// GuardrailsStrategy is the Guyton-Klinger rules, it withdraws Rate of capital per year in the first run,
+// then the withdrawal of the previous run adjusted by inflation, or not adjusted if capital falls since the previous withdrawal.
+// The withdrawal is cut by Adjust when it's more than Rate by Band of capital, e.g. 20% more,
+// and it's raised by Adjust when it's less than Rate by Band.
+// It fails once the withdrawal is less than the inflation adjusted cost of living.
+type GuardrailsStrategy struct {
+	Rate   float64
+	Band   float64
+	Adjust float64
+}
+
+func (s GuardrailsStrategy) Withdraw(config *Config, datePrices []DatePrice, state *RunState, logger Logger) (int, float64, error) {
+	capital := state.Capital(state.StartIndex)
+	withdrawal := capital * s.Rate * state.years()
+	rule := "initial"
+	if state.Run > 0 {
+		withdrawal, rule = state.Withdrawn*state.Fraction, "frozen after a fall"
+		if capital >= state.PrevCapital {
+			withdrawal, rule = withdrawal*state.InflationRate/state.PrevInflationRate, "inflation adjusted"
+		}
+		switch rate := withdrawal / capital / state.years(); {
+		case rate > s.Rate*(1+s.Band):
+			withdrawal, rule = withdrawal*(1-s.Adjust), fmt.Sprintf("cut, rate %.4f is above the upper guardrail", rate)
+		case rate < s.Rate*(1-s.Band):
+			withdrawal, rule = withdrawal*(1+s.Adjust), fmt.Sprintf("raised, rate %.4f is below the lower guardrail", rate)
+		}
+	}
+	floor := state.costOfLiving()
+	logger.Tracef("%s to %s, capital %d, withdraw %d (%s), cost of living %d\n",
+		toyyyymmdd(datePrices[state.StartIndex].Date),
+		toyyyymmdd(datePrices[state.EndIndex].Date),
+		int(config.traced(capital, state.InflationRate)),
+		int(config.traced(withdrawal, state.InflationRate)),
+		rule,
+		int(config.traced(floor, state.InflationRate)),
+	)
+
+	if withdrawal < floor {
+		return state.StartIndex, 0, errors.New("withdrawal is cut below cost of living")
+	}
+	return state.StartIndex, withdrawal, nil
+}
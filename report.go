@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aaron0x/rearview/rearview"
+)
+
+// printMarkdown prints a report of config and result as markdown
+func printMarkdown(config *rearview.Config, strategyName string, datePrices []rearview.DatePrice, result rearview.StrategyResult, logger rearview.Logger) {
+	inflation := fmt.Sprintf("%g per year", config.InflationRate)
+	if config.CPI != nil {
+		inflation = "cpi"
+	}
+	logger.Printf("# Rearview report\n\n")
+	logger.Printf("| Parameter | Value |\n|---|---|\n")
+	logger.Printf("| Data | %s to %s, %d days |\n", toyyyymmdd(datePrices[0].Date), toyyyymmdd(datePrices[len(datePrices)-1].Date), len(datePrices))
+	logger.Printf("| Capital | %d |\n", config.Capital)
+	logger.Printf("| Cost per year | %d |\n", config.CostPerYear)
+	logger.Printf("| Runs | %d of %d years |\n", config.Run, config.YearPerRun)
+	logger.Printf("| Inflation | %s |\n", inflation)
+	logger.Printf("| Strategy | %s |\n", strategyName)
+	logger.Printf("| Price | %s |\n", config.PriceField)
+	logger.Printf("| Tax rate | %g |\n", config.TaxRate)
+	logger.Printf("| Fee | %s |\n", config.Fee.String())
+	if config.Bonds != nil {
+		logger.Printf("| Stocks/bonds | %g/%g |\n", config.StockWeight*100, (1-config.StockWeight)*100)
+	}
+	if config.CashWeight > 0 {
+		logger.Printf("| Cash | %g%% earning %g per year |\n", config.CashWeight*100, config.CashReturn)
+	}
+
+	logger.Printf("\n## Summary\n\n")
+	logger.Printf("| Success | Failed | N/A | Successful rate |\n|---|---|---|---|\n")
+	rate := "no completed periods"
+	if result.Completed() > 0 {
+		rate = fmt.Sprintf("%f", result.SuccessRate)
+	}
+	logger.Printf("| %d | %d | %d | %s |\n", result.SuccessCount, result.FailedCount, result.NACount, rate)
+	if config.NAAsFailed {
+		logger.Printf("\nN/A is counted as failed in the successful rate.\n")
+	}
+
+	if result.Completed() > 0 {
+		logger.Printf("\n## Ending value of completed start days in dollars of start day, failed ones as 0\n\n")
+		logger.Printf("| P5 | P25 |\n|---|---|\n")
+		logger.Printf("| %d | %d |\n", int64(result.TailValue.P5), int64(result.TailValue.P25))
+	}
+
+	if result.SuccessCount > 0 {
+		value := result.EndingValue
+		logger.Printf("\n## Ending value of success in dollars of start day\n\n")
+		logger.Printf("| Min | P10 | Median | P90 | Max |\n|---|---|---|---|---|\n")
+		logger.Printf("| %d | %d | %d | %d | %d |\n", int64(value.Min), int64(value.P10), int64(value.Median), int64(value.P90), int64(value.Max))
+		logger.Printf("\nWorst success starts on %s ending with %d, best success starts on %s ending with %d.\n",
+			toyyyymmdd(result.WorstSuccess.Start), int64(result.WorstSuccess.EndingValue),
+			toyyyymmdd(result.BestSuccess.Start), int64(result.BestSuccess.EndingValue))
+	}
+
+	if result.FailedCount > 0 {
+		logger.Printf("\n## Failures by run\n\n")
+		logger.Printf("| Run | Failed | Share of failures |\n|---|---|---|\n")
+		for i, count := range result.FailedRuns {
+			logger.Printf("| %d | %d | %.1f%% |\n", i+1, count, float64(count)/float64(result.FailedCount)*100)
+		}
+	}
+}
+
+// printStats prints stats of the price series, as a line of json in json format
+func printStats(stats rearview.SeriesStats, format string, logger rearview.Logger) error {
+	if format == "json" {
+		encoded, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(encoded))
+		return nil
+	}
+	logger.Printf("%s to %s: growth rate %.2f%% per year, max drawdown %.2f%% from %s to %s\n",
+		toyyyymmdd(stats.Start), toyyyymmdd(stats.End), stats.CAGR*100,
+		stats.MaxDrawdown*100, toyyyymmdd(stats.PeakDate), toyyyymmdd(stats.TroughDate))
+	if stats.LongestRecovery > 0 {
+		recovery := fmt.Sprintf("recovered on %s", toyyyymmdd(stats.RecoveryDate))
+		if !stats.Recovered {
+			recovery = fmt.Sprintf("not recovered by %s", toyyyymmdd(stats.RecoveryDate))
+		}
+		logger.Printf("longest recovery %.1f years from the peak of %s, %s\n",
+			stats.LongestRecovery, toyyyymmdd(stats.RecoveryPeakDate), recovery)
+	}
+	return nil
+}
+
+// horizon describes how long a period of config is like 5 runs of 10 years
+func horizon(config *rearview.Config) string {
+	runs := fmt.Sprintf("%d runs of %d years", config.Run, config.YearPerRun)
+	if config.AccumulateYears > 0 {
+		runs += fmt.Sprintf(" after %d years of accumulation", config.AccumulateYears)
+	}
+	return runs
+}
+
+// progressLine shows how far checking is on one line updated in place,
+// methods do nothing on a nil progressLine so callers don't check -progress
+type progressLine struct {
+	writer io.Writer
+	start  time.Time
+	// checks is how many checks of every start day or trial there are,
+	// 0 is unknown, only progress of the current check is shown then
+	checks int
+	// check is how many checks are finished
+	check   int
+	printed time.Time
+	width   int
+}
+
+func (p *progressLine) setChecks(checks int) {
+	if p != nil {
+		p.checks = checks
+	}
+}
+
+// report prints progress of the current check at most every 100ms
+func (p *progressLine) report(done, total int) {
+	now := time.Now()
+	if now.Sub(p.printed) < 100*time.Millisecond && done < total {
+		return
+	}
+	p.printed = now
+
+	fraction := float64(done) / float64(total)
+	line := ""
+	if p.checks > 1 {
+		fraction = (float64(p.check) + fraction) / float64(p.checks)
+		line = fmt.Sprintf("check %d/%d, ", p.check+1, p.checks)
+	}
+	elapsed := now.Sub(p.start)
+	line += fmt.Sprintf("%.1f%% done, elapsed %s", fraction*100, elapsed.Round(time.Second))
+	if fraction > 0 && p.checks != 0 {
+		eta := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+		line += fmt.Sprintf(", eta %s", eta.Round(time.Second))
+	}
+	// pad to overwrite a longer line printed before
+	width := len(line)
+	if width < p.width {
+		line += strings.Repeat(" ", p.width-width)
+	}
+	p.width = width
+	fmt.Fprintf(p.writer, "\r%s", line)
+}
+
+// next clears the line after a check
+func (p *progressLine) next() {
+	if p == nil {
+		return
+	}
+	p.check++
+	if p.width > 0 {
+		fmt.Fprintf(p.writer, "\r%s\r", strings.Repeat(" ", p.width))
+		p.width = 0
+	}
+}
+
+// writeResultCSV writes start day, outcome, runs reached and ending value of periods to path
+func writeResultCSV(path string, periods []rearview.PeriodResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"Start", "Outcome", "Runs", "Ending Value"})
+	for _, period := range periods {
+		writer.Write([]string{
+			toyyyymmdd(period.Start),
+			period.Status.String(),
+			strconv.Itoa(period.Runs),
+			strconv.FormatFloat(period.EndingValue, 'f', 2, 64),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// printStarts prints the period of each of starts, periods are in the order of starts
+func printStarts(starts []time.Time, periods []rearview.PeriodResult, logger rearview.Logger) {
+	for i, period := range periods {
+		start := toyyyymmdd(starts[i])
+		if !period.Start.Equal(starts[i]) && period.Status != rearview.NA {
+			start += " (closest day " + toyyyymmdd(period.Start) + ")"
+		}
+		switch period.Status {
+		case rearview.Success:
+			logger.Printf("%s: success, ending value %d in dollars of start day\n", start, int64(period.EndingValue))
+		case rearview.Failed:
+			logger.Printf("%s: failed in run %d on %s, %s\n", start, period.Runs, toyyyymmdd(period.FailedDate), period.FailedReason)
+		default:
+			logger.Printf("%s: N/A, not enough data\n", start)
+		}
+	}
+}
+
+// periodLine is a result of a period in jsonl format
+type periodLine struct {
+	Start        string   `json:"start"`
+	Capital      int64    `json:"capital"`
+	CostPerYear  int      `json:"costPerYear"`
+	Status       string   `json:"status"`
+	Runs         int      `json:"runs"`
+	Years        float64  `json:"years"`
+	EndingValue  float64  `json:"endingValue"`
+	FailedDate   string   `json:"failedDate,omitempty"`
+	FailedReason string   `json:"failedReason,omitempty"`
+	IRR          *float64 `json:"irr,omitempty"`
+}
+
+func newPeriodLine(config *rearview.Config, period rearview.PeriodResult) periodLine {
+	line := periodLine{
+		Start:        toyyyymmdd(period.Start),
+		Capital:      period.Capital,
+		CostPerYear:  config.CostPerYear,
+		Status:       period.Status.String(),
+		Runs:         period.Runs,
+		Years:        period.Years,
+		EndingValue:  period.EndingValue,
+		FailedReason: period.FailedReason,
+	}
+	if period.Status == rearview.Failed {
+		line.FailedDate = toyyyymmdd(period.FailedDate)
+	}
+	if config.IRR && period.Status != rearview.NA && !math.IsNaN(period.IRR) {
+		line.IRR = &period.IRR
+	}
+	return line
+}
+
+// writeTrajectoryCSV writes the portfolio at every run boundary of periods to path
+func writeTrajectoryCSV(path string, periods []rearview.PeriodResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"Start", "Run", "Date", "Value", "Real Value", "Shares", "Bond Shares", "Cash"})
+	for _, period := range periods {
+		for _, point := range period.Trajectory {
+			writer.Write([]string{
+				toyyyymmdd(period.Start),
+				strconv.Itoa(point.Run),
+				toyyyymmdd(point.Date),
+				strconv.FormatFloat(point.Value, 'f', 2, 64),
+				strconv.FormatFloat(point.RealValue, 'f', 2, 64),
+				strconv.FormatFloat(point.Shares, 'f', 4, 64),
+				strconv.FormatFloat(point.BondShares, 'f', 4, 64),
+				strconv.FormatFloat(point.Cash, 'f', 2, 64),
+			})
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// writeLedgerCSV writes every sale of period to path
+func writeLedgerCSV(path string, period rearview.PeriodResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"Date", "Asset", "Shares Sold", "Price", "Proceeds", "Tax", "Fee", "Shares Held"})
+	for _, sale := range period.Sales {
+		writer.Write([]string{
+			toyyyymmdd(sale.Date),
+			sale.Asset,
+			strconv.FormatFloat(sale.Shares, 'f', 4, 64),
+			strconv.FormatFloat(sale.Price, 'f', 4, 64),
+			strconv.FormatFloat(sale.Proceeds, 'f', 2, 64),
+			strconv.FormatFloat(sale.Tax, 'f', 2, 64),
+			strconv.FormatFloat(sale.Fee, 'f', 2, 64),
+			strconv.FormatFloat(sale.Remained, 'f', 4, 64),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func toyyyymmdd(date time.Time) string {
+	return date.Format("2006-01-02")
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aaron0x/rearview/rearview"
+)
+
+// printBuckets prints successful rate of periods grouped by years of their start day
+func printBuckets(periods []rearview.PeriodResult, years int, naAsFailed bool, style tableStyle, logger rearview.Logger) {
+	buckets := map[int][]rearview.PeriodResult{}
+	starts := []int{}
+	for _, period := range periods {
+		start := period.Start.Year() / years * years
+		if _, ok := buckets[start]; !ok {
+			starts = append(starts, start)
+		}
+		buckets[start] = append(buckets[start], period)
+	}
+	sort.Ints(starts)
+
+	table := newTable(style, "start", "success", "failed", "N/A", "successful rate")
+	for _, start := range starts {
+		table.addResult(fmt.Sprintf("%d-%d", start, start+years-1), rearview.Summarize(buckets[start], naAsFailed))
+	}
+	table.print(logger)
+}
+
+// printSequenceRisk prints successful rate of periods with a drawdown of at least drawdown within years after their start day
+// versus periods without one
+func printSequenceRisk(datePrices []rearview.DatePrice, periods []rearview.PeriodResult, drawdown float64, years int, naAsFailed bool, style tableStyle, logger rearview.Logger) {
+	crashed, calm := []rearview.PeriodResult{}, []rearview.PeriodResult{}
+	for _, period := range periods {
+		if rearview.DrawdownWithin(datePrices, period.Start, years) >= drawdown {
+			crashed = append(crashed, period)
+		} else {
+			calm = append(calm, period)
+		}
+	}
+
+	table := newTable(style, "start days", "success", "failed", "N/A", "successful rate")
+	table.addResult(fmt.Sprintf("%.0f%% drawdown in %d years", drawdown*100, years), rearview.Summarize(crashed, naAsFailed))
+	table.addResult("no early drawdown", rearview.Summarize(calm, naAsFailed))
+	table.print(logger)
+}
+
+// printCapitalBins prints successful rate of periods by their capital in up to 10 bins of [low, high], a bin is at least a dollar
+func printCapitalBins(periods []rearview.PeriodResult, low, high int64, naAsFailed bool, style tableStyle, logger rearview.Logger) {
+	count := high - low + 1
+	bins := int64(10)
+	if count < bins {
+		bins = count
+	}
+	// bin i is capitals from from(i) to before from(i+1)
+	from := func(i int64) int64 {
+		return low + i*count/bins
+	}
+	binned := make([][]rearview.PeriodResult, bins)
+	for _, period := range periods {
+		// the last i with from(i) <= capital
+		bin := ((period.Capital-low+1)*bins - 1) / count
+		binned[bin] = append(binned[bin], period)
+	}
+	table := newTable(style, "capital", "trials", "success", "failed", "N/A", "successful rate")
+	for i, periods := range binned {
+		label := strconv.FormatInt(from(int64(i)), 10)
+		if to := from(int64(i)+1) - 1; to != from(int64(i)) {
+			label += "-" + strconv.FormatInt(to, 10)
+		}
+		table.addResult(label, rearview.Summarize(periods, naAsFailed), strconv.Itoa(len(periods)))
+	}
+	table.print(logger)
+}
+
+// tableStyle is how a table is printed
+type tableStyle int
+
+const (
+	alignedTable tableStyle = iota
+	tsvTable
+)
+
+// parseTableStyle parses -table, auto is aligned if out is a terminal
+func parseTableStyle(value string, out io.Writer) (tableStyle, error) {
+	switch value {
+	case "aligned":
+		return alignedTable, nil
+	case "tsv":
+		return tsvTable, nil
+	case "auto":
+		if file, ok := out.(*os.File); ok {
+			if info, err := file.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+				return alignedTable, nil
+			}
+		}
+		return tsvTable, nil
+	default:
+		return alignedTable, fmt.Errorf("unknown -table %s, expect auto, aligned or tsv", value)
+	}
+}
+
+// table is rows of cells, the first row is the header.
+// Aligned columns of numbers are right aligned and other columns left aligned.
+type table struct {
+	style tableStyle
+	rows  [][]string
+}
+
+func newTable(style tableStyle, header ...string) *table {
+	return &table{style: style, rows: [][]string{header}}
+}
+
+func (t *table) add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// addResult adds a row of name, more cells, then counts and successful rate of result
+func (t *table) addResult(name string, result rearview.StrategyResult, more ...string) {
+	rate := "-"
+	if result.Completed() > 0 {
+		rate = fmt.Sprintf("%f", result.SuccessRate)
+	}
+	cells := append([]string{name}, more...)
+	t.add(append(cells, strconv.Itoa(result.SuccessCount), strconv.Itoa(result.FailedCount), strconv.Itoa(result.NACount), rate)...)
+}
+
+func (t *table) print(logger rearview.Logger) {
+	if t.style == tsvTable {
+		for _, row := range t.rows {
+			logger.Printf("%s\n", strings.Join(row, "\t"))
+		}
+		return
+	}
+
+	widths, numeric := []int{}, []bool{}
+	for i, row := range t.rows {
+		for j, cell := range row {
+			if j == len(widths) {
+				widths, numeric = append(widths, 0), append(numeric, true)
+			}
+			if n := utf8.RuneCountInString(cell); n > widths[j] {
+				widths[j] = n
+			}
+			// - is a number which is missing
+			if _, err := strconv.ParseFloat(cell, 64); i > 0 && err != nil && cell != "-" && cell != "" {
+				numeric[j] = false
+			}
+		}
+	}
+	for _, row := range t.rows {
+		line := ""
+		for j, cell := range row {
+			padding := strings.Repeat(" ", widths[j]-utf8.RuneCountInString(cell))
+			if j > 0 {
+				line += "  "
+			}
+			if numeric[j] {
+				line += padding + cell
+			} else {
+				line += cell + padding
+			}
+		}
+		logger.Printf("%s\n", strings.TrimRight(line, " "))
+	}
+}
+
+// compare checks config with strategies on the same data and prints their results side by side, names[i] is the name of strategies[i]
+func compare(config rearview.Config, names []string, strategies []rearview.Strategy, format string, style tableStyle, check func(*rearview.Config) (rearview.StrategyResult, error), logger rearview.Logger) error {
+	results := make([]rearview.StrategyResult, len(names))
+	for i, name := range names {
+		config.Strategy = strategies[i]
+		result, err := check(&config)
+		if err != nil {
+			return fmt.Errorf("interrupted while checking strategy %s", name)
+		}
+		results[i] = result
+	}
+
+	if format == "json" {
+		byName := map[string]rearview.StrategyResult{}
+		for i, name := range names {
+			byName[name] = results[i]
+		}
+		encoded, err := json.Marshal(byName)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(encoded))
+		return nil
+	}
+	rows := []struct {
+		name  string
+		value func(result *rearview.StrategyResult) string
+	}{
+		{"success", func(r *rearview.StrategyResult) string { return strconv.Itoa(r.SuccessCount) }},
+		{"failed", func(r *rearview.StrategyResult) string { return strconv.Itoa(r.FailedCount) }},
+		{"N/A", func(r *rearview.StrategyResult) string { return strconv.Itoa(r.NACount) }},
+		{"successful rate", func(r *rearview.StrategyResult) string { return strconv.FormatFloat(r.SuccessRate, 'f', 6, 64) }},
+		{"ending value p5", func(r *rearview.StrategyResult) string { return strconv.FormatInt(int64(r.TailValue.P5), 10) }},
+		{"ending value p25", func(r *rearview.StrategyResult) string { return strconv.FormatInt(int64(r.TailValue.P25), 10) }},
+		{"ending value min", func(r *rearview.StrategyResult) string { return strconv.FormatInt(int64(r.EndingValue.Min), 10) }},
+		{"ending value p10", func(r *rearview.StrategyResult) string { return strconv.FormatInt(int64(r.EndingValue.P10), 10) }},
+		{"ending value median", func(r *rearview.StrategyResult) string { return strconv.FormatInt(int64(r.EndingValue.Median), 10) }},
+		{"ending value p90", func(r *rearview.StrategyResult) string { return strconv.FormatInt(int64(r.EndingValue.P90), 10) }},
+		{"ending value max", func(r *rearview.StrategyResult) string { return strconv.FormatInt(int64(r.EndingValue.Max), 10) }},
+	}
+	table := newTable(style, append([]string{""}, names...)...)
+	for _, row := range rows {
+		cells := []string{row.name}
+		for i := range results {
+			cells = append(cells, row.value(&results[i]))
+		}
+		table.add(cells...)
+	}
+	table.print(logger)
+	return nil
+}
+
+// sweep checks config with every cost per year of costs and prints their successful rate,
+// rates checked before an interruption are printed too, it fails if it's interrupted or no cost meets minRate
+func sweep(config rearview.Config, costs []int64, minRate float64, format string, style tableStyle, check func(*rearview.Config) (rearview.StrategyResult, error), logger rearview.Logger) error {
+	type costRate struct {
+		CostPerYear int64   `json:"costPerYear"`
+		SuccessRate float64 `json:"successRate"`
+		Completed   int     `json:"completed"`
+	}
+	rates := []costRate{}
+	highest := -1
+	var interrupted error
+	for _, cost := range costs {
+		config.CostPerYear = int(cost)
+		result, err := check(&config)
+		if err != nil {
+			interrupted = fmt.Errorf("interrupted while checking cost per year %d", cost)
+			break
+		}
+		rates = append(rates, costRate{cost, result.SuccessRate, result.Completed()})
+		if result.Completed() > 0 && result.SuccessRate >= minRate {
+			highest = len(rates) - 1
+		}
+	}
+
+	if format == "json" {
+		encoded, err := json.Marshal(rates)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(encoded))
+	} else {
+		table := newTable(style, "cost per year", "successful rate", "")
+		for i, rate := range rates {
+			successRate, mark := fmt.Sprintf("%f", rate.SuccessRate), ""
+			if rate.Completed == 0 {
+				successRate, mark = "-", "no completed periods"
+			}
+			if i == highest {
+				mark = fmt.Sprintf("<- highest meeting -min-rate %f", minRate)
+			}
+			table.add(strconv.FormatInt(rate.CostPerYear, 10), successRate, mark)
+		}
+		table.print(logger)
+	}
+	if interrupted != nil {
+		return interrupted
+	}
+	if highest < 0 {
+		return errExit
+	}
+	return nil
+}
+
+// grid checks config with every capital of capitals and cost per year of costs,
+// and writes a csv of successful rate with a row per capital and a column per cost as rows are done,
+// or prints an aligned table of them when all are done, or json of every pair with -format json
+func grid(out io.Writer, config rearview.Config, capitals, costs []int64, format string, style tableStyle, check func(*rearview.Config) (rearview.StrategyResult, error), logger rearview.Logger) error {
+	if format == "json" {
+		type capitalCostRate struct {
+			Capital     int64   `json:"capital"`
+			CostPerYear int64   `json:"costPerYear"`
+			SuccessRate float64 `json:"successRate"`
+			Completed   int     `json:"completed"`
+		}
+		rates := []capitalCostRate{}
+		for _, capital := range capitals {
+			config.Capital = capital
+			for _, cost := range costs {
+				config.CostPerYear = int(cost)
+				result, err := check(&config)
+				if err != nil {
+					return fmt.Errorf("interrupted while checking capital %d and cost per year %d", capital, cost)
+				}
+				rates = append(rates, capitalCostRate{capital, cost, result.SuccessRate, result.Completed()})
+			}
+		}
+		encoded, err := json.Marshal(rates)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(encoded))
+		return nil
+	}
+
+	writer := csv.NewWriter(out)
+	defer writer.Flush()
+	header := []string{"Capital"}
+	for _, cost := range costs {
+		header = append(header, strconv.FormatInt(cost, 10))
+	}
+	table := newTable(style, header...)
+	if style == alignedTable {
+		// an aligned table is printed when it's done, also with the rows before an interruption
+		defer table.print(logger)
+	} else {
+		writer.Write(header)
+	}
+	for _, capital := range capitals {
+		config.Capital = capital
+		row := []string{strconv.FormatInt(capital, 10)}
+		for _, cost := range costs {
+			config.CostPerYear = int(cost)
+			result, err := check(&config)
+			if err != nil {
+				return fmt.Errorf("interrupted while checking capital %d and cost per year %d", capital, cost)
+			}
+			rate := ""
+			if result.Completed() > 0 {
+				rate = strconv.FormatFloat(result.SuccessRate, 'f', 6, 64)
+			}
+			row = append(row, rate)
+		}
+		if style == alignedTable {
+			table.add(row...)
+			continue
+		}
+		writer.Write(row)
+		// show rows as they are done, a grid takes a while
+		writer.Flush()
+	}
+	return nil
+}